@@ -3,6 +3,9 @@ module your_project_name
 go 1.22.5
 
 require (
-	github.com/aws/aws-sdk-go v1.55.5 // indirect
-	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/aws/aws-sdk-go v1.55.5
+	golang.org/x/time v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
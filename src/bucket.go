@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// HeadBucket でバケットが別リージョンにある場合に返るエラーコード
+const errCodeBucketRegion = "BucketRegionError"
+
+// バケットが存在しないことを表すエラー
+var errBucketNotFound = errors.New("bucket does not exist")
+
+// 処理を始める前に、バケットが存在し設定したリージョンから使えることを確認する
+// バケット名やリージョンの誤りで、翻訳や音声合成を行ってから失敗するのを防ぐ
+func checkBucket(ctx context.Context, s3Svc S3API, cfg *Config) error {
+	callCtx, cancel := callContext(ctx, cfg.Timeout)
+	defer cancel()
+	_, err := s3Svc.HeadBucketWithContext(callCtx, &s3.HeadBucketInput{
+		Bucket: aws.String(cfg.Bucket),
+	})
+	if err == nil {
+		return nil
+	}
+
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		switch {
+		case reqErr.Code() == errCodeBucketRegion:
+			// メッセージに実際のリージョンが含まれる
+			return fmt.Errorf("bucket %s is not in region %s (fix --region): %s", cfg.Bucket, cfg.Region, reqErr.Message())
+		case reqErr.StatusCode() == http.StatusNotFound:
+			return fmt.Errorf("bucket %s: %w (use --create-bucket to create it)", cfg.Bucket, errBucketNotFound)
+		case reqErr.StatusCode() == http.StatusForbidden:
+			return fmt.Errorf("access to bucket %s is denied (check the bucket policy and credentials): %w", cfg.Bucket, err)
+		}
+	}
+	return fmt.Errorf("checking bucket %s: %w", cfg.Bucket, err)
+}
+
+// バケットを確認し、存在しなければ --create-bucket の場合に作成する
+func ensureBucket(ctx context.Context, s3Svc S3API, cfg *Config) error {
+	err := checkBucket(ctx, s3Svc, cfg)
+	if !errors.Is(err, errBucketNotFound) || !cfg.CreateBucket {
+		return err
+	}
+	return createBucket(ctx, s3Svc, cfg)
+}
+
+// 設定したリージョンにバケットを作成し、使えるようになるまで待つ
+func createBucket(ctx context.Context, s3Svc S3API, cfg *Config) error {
+	input := &s3.CreateBucketInput{
+		Bucket: aws.String(cfg.Bucket),
+	}
+	// us-east-1 では LocationConstraint を指定するとエラーになる
+	if cfg.Region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(cfg.Region),
+		}
+	}
+	callCtx, cancel := callContext(ctx, cfg.Timeout)
+	defer cancel()
+	_, err := s3Svc.CreateBucketWithContext(callCtx, input)
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case s3.ErrCodeBucketAlreadyOwnedByYou:
+			return nil
+		case s3.ErrCodeBucketAlreadyExists:
+			return fmt.Errorf("bucket name %s is already taken by another AWS account (bucket names are global; choose a different --bucket): %w", cfg.Bucket, err)
+		case "AccessDenied":
+			return fmt.Errorf("not allowed to create bucket %s (check s3:CreateBucket permission): %w", cfg.Bucket, err)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("creating bucket %s: %w", cfg.Bucket, err)
+	}
+
+	if err := s3Svc.WaitUntilBucketExistsWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(cfg.Bucket)}); err != nil {
+		return fmt.Errorf("waiting for bucket %s: %w", cfg.Bucket, err)
+	}
+	return nil
+}
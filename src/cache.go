@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 翻訳キャッシュのキー（同じ原文でも言語の組み合わせや翻訳の設定が違えば別の訳になる）
+type cacheKey struct {
+	SourceLang string `json:"source_lang"`
+	TargetLang string `json:"target_lang"`
+	Settings   string `json:"settings,omitempty"`
+	Text       string `json:"text"`
+}
+
+// --translation-cache のファイルに保存する1件分
+type cacheEntry struct {
+	cacheKey
+	Translation  string `json:"translation"`
+	DetectedLang string `json:"detected_lang,omitempty"`
+}
+
+// 同じ行を同じ実行の中で何度も翻訳しないためのキャッシュ（ワーカーから同時に使われる）
+type translationCache struct {
+	mu      sync.Mutex
+	entries map[cacheKey]translationResult
+
+	// --dynamodb-table の翻訳メモリー（なければ nil）
+	remote *translationMemory
+
+	hits       atomic.Int64
+	misses     atomic.Int64
+	remoteHits atomic.Int64 // hits のうち翻訳メモリーから取得した数
+}
+
+func newTranslationCache() *translationCache {
+	return &translationCache{entries: make(map[cacheKey]translationResult)}
+}
+
+// 訳文に影響する翻訳の設定をキーに含める文字列にする
+func translationSettings(cfg *Config) string {
+	var settings []string
+	if cfg.Formality != "" {
+		settings = append(settings, "formality="+cfg.Formality)
+	}
+	if cfg.MaskProfanity {
+		settings = append(settings, "profanity=MASK")
+	}
+	if len(cfg.Terminologies) > 0 {
+		settings = append(settings, "terminology="+strings.Join(cfg.Terminologies, ","))
+	}
+	if cfg.ProtectPattern != "" {
+		settings = append(settings, "protect="+cfg.ProtectPattern)
+	}
+	return strings.Join(settings, ";")
+}
+
+func (c *translationCache) get(key cacheKey) (translationResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, ok := c.entries[key]
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return res, ok
+}
+
+func (c *translationCache) put(key cacheKey, res translationResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = res
+}
+
+// get で見つからなかった訳文を翻訳メモリーから探し、見つかればメモリーにも入れる
+func (c *translationCache) getRemote(ctx context.Context, key cacheKey) (translationResult, bool, error) {
+	if c.remote == nil {
+		return translationResult{}, false, nil
+	}
+	res, ok, err := c.remote.get(ctx, key)
+	if err != nil || !ok {
+		return res, ok, err
+	}
+	c.misses.Add(-1)
+	c.hits.Add(1)
+	c.remoteHits.Add(1)
+	c.put(key, res)
+	return res, true, nil
+}
+
+// 翻訳した訳文を翻訳メモリーにも記録する
+func (c *translationCache) putRemote(ctx context.Context, key cacheKey, res translationResult) error {
+	if c.remote == nil {
+		return nil
+	}
+	return c.remote.put(ctx, key, res)
+}
+
+// 前回の実行で保存したキャッシュを読み込む（ファイルがなければ空のまま）
+func (c *translationCache) load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []cacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range entries {
+		c.entries[e.cacheKey] = translationResult{Text: e.Translation, DetectedLang: e.DetectedLang}
+	}
+	return nil
+}
+
+// キャッシュをファイルに保存する
+func (c *translationCache) save(path string) error {
+	c.mu.Lock()
+	entries := make([]cacheEntry, 0, len(c.entries))
+	for key, res := range c.entries {
+		entries = append(entries, cacheEntry{cacheKey: key, Translation: res.Text, DetectedLang: res.DetectedLang})
+	}
+	c.mu.Unlock()
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// この行数を書くごとにチェックポイントファイルをディスクに同期する
+const checkpointSyncEvery = 20
+
+// チェックポイントに記録する、完了した1行・1翻訳先言語分
+type checkpointEntry struct {
+	InputPath   string `json:"input_path"`
+	Line        int    `json:"line"`
+	TargetLang  string `json:"target_lang"`
+	Text        string `json:"text"` // 入力が変わった行を再処理するために原文も記録する
+	Translation string `json:"translation"`
+}
+
+type checkpointKey struct {
+	InputPath  string
+	Line       int
+	TargetLang string
+}
+
+// --checkpoint のファイル（完了した行を1行1件のJSONで追記する）
+type checkpoint struct {
+	mu      sync.Mutex
+	file    *os.File
+	done    map[checkpointKey]checkpointEntry
+	pending int // 最後の同期以降に書いた件数
+}
+
+// チェックポイントファイルを読み込み、追記用に開く（なければ作成する）
+// 中断時に書きかけだった最後の行は読み飛ばす
+func openCheckpoint(path string) (*checkpoint, error) {
+	c := &checkpoint{done: make(map[checkpointKey]checkpointEntry)}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading checkpoint %s: %w", path, err)
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var e checkpointEntry
+		if json.Unmarshal(line, &e) != nil {
+			continue
+		}
+		c.done[checkpointKey{e.InputPath, e.Line, e.TargetLang}] = e
+	}
+
+	if c.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return nil, err
+	}
+	// 書きかけの行の後ろに続けて書かないよう、改行で終わっていなければ改行を補う
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		if _, err := c.file.Write([]byte("\n")); err != nil {
+			c.file.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// 完了済みの行なら記録した訳文を返す（原文が変わっていれば未完了とみなす）
+func (c *checkpoint) lookup(inputPath string, line int, targetLang, text string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.done[checkpointKey{inputPath, line, targetLang}]
+	if !ok || e.Text != text {
+		return "", false
+	}
+	return e.Translation, true
+}
+
+// 完了した行を追記する
+func (c *checkpoint) record(res LineResult) error {
+	data, err := json.Marshal(checkpointEntry{
+		InputPath:   res.InputPath,
+		Line:        res.Line,
+		TargetLang:  res.TargetLang,
+		Text:        res.Text,
+		Translation: res.Translation,
+	})
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	c.pending++
+	if c.pending >= checkpointSyncEvery {
+		c.pending = 0
+		return c.file.Sync()
+	}
+	return nil
+}
+
+// 残りを同期してファイルを閉じる
+func (c *checkpoint) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.file.Sync(); err != nil {
+		c.file.Close()
+		return err
+	}
+	return c.file.Close()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/transcribeservice"
+)
+
+// DeleteObjects で一度に削除できるオブジェクト数
+const maxDeleteObjects = 1000
+
+// このツールが作成したS3オブジェクトかどうか
+// （音声ファイルと、--job-prefix のジョブの結果JSONだけを対象にし、他のオブジェクトは消さない）
+func isGeneratedObject(cfg *Config, key string) bool {
+	name := path.Base(key)
+	if strings.HasPrefix(key, cfg.S3Prefix) && strings.HasPrefix(name, audioFilePrefix) {
+		return true
+	}
+	return strings.HasPrefix(key, cfg.TranscribeOutputPrefix) && strings.HasPrefix(name, cfg.JobPrefix+"-") && strings.HasSuffix(name, ".json")
+}
+
+// --s3-prefix と --transcribe-output-prefix の下にある、このツールが作成したオブジェクトのキーを列挙する
+func listGeneratedObjects(ctx context.Context, s3Svc S3API, cfg *Config) ([]string, error) {
+	prefixes := []string{cfg.S3Prefix}
+	if cfg.TranscribeOutputPrefix != cfg.S3Prefix {
+		prefixes = append(prefixes, cfg.TranscribeOutputPrefix)
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	for _, prefix := range prefixes {
+		input := &s3.ListObjectsV2Input{
+			Bucket: aws.String(cfg.Bucket),
+			Prefix: aws.String(prefix),
+		}
+		for {
+			var output *s3.ListObjectsV2Output
+			err := withRetry(ctx, cfg, func() error {
+				callCtx, cancel := callContext(ctx, cfg.Timeout)
+				defer cancel()
+				var err error
+				output, err = s3Svc.ListObjectsV2WithContext(callCtx, input)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, obj := range output.Contents {
+				key := aws.StringValue(obj.Key)
+				if !seen[key] && isGeneratedObject(cfg, key) {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			}
+			if !aws.BoolValue(output.IsTruncated) {
+				break
+			}
+			input.ContinuationToken = output.NextContinuationToken
+		}
+	}
+	return keys, nil
+}
+
+// オブジェクトを DeleteObjects でまとめて削除し、削除できた数を返す
+func deleteObjects(ctx context.Context, s3Svc S3API, cfg *Config, keys []string) (int, error) {
+	deleted := 0
+	for start := 0; start < len(keys); start += maxDeleteObjects {
+		end := min(start+maxDeleteObjects, len(keys))
+		objects := make([]*s3.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		var output *s3.DeleteObjectsOutput
+		err := withRetry(ctx, cfg, func() error {
+			callCtx, cancel := callContext(ctx, cfg.Timeout)
+			defer cancel()
+			var err error
+			output, err = s3Svc.DeleteObjectsWithContext(callCtx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(cfg.Bucket),
+				Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+			})
+			return err
+		})
+		if err != nil {
+			return deleted, err
+		}
+		// Quiet の場合、削除できなかったオブジェクトだけが Errors に返る
+		deleted += len(objects) - len(output.Errors)
+		if len(output.Errors) > 0 {
+			e := output.Errors[0]
+			return deleted, fmt.Errorf("%d objects could not be deleted (e.g. %s: %s)", len(output.Errors), aws.StringValue(e.Key), aws.StringValue(e.Message))
+		}
+	}
+	return deleted, nil
+}
+
+// 文字起こしジョブを1つずつ削除し、削除できた数を返す
+func deleteTranscriptionJobs(ctx context.Context, transcribeSvc TranscribeAPI, cfg *Config, jobs []*transcribeservice.TranscriptionJobSummary) (int, error) {
+	for i, job := range jobs {
+		err := withRetry(ctx, cfg, func() error {
+			callCtx, cancel := callContext(ctx, cfg.Timeout)
+			defer cancel()
+			_, err := transcribeSvc.DeleteTranscriptionJobWithContext(callCtx, &transcribeservice.DeleteTranscriptionJobInput{
+				TranscriptionJobName: job.TranscriptionJobName,
+			})
+			return err
+		})
+		if err != nil {
+			return i, fmt.Errorf("deleting transcription job %s: %w", aws.StringValue(job.TranscriptionJobName), err)
+		}
+	}
+	return len(jobs), nil
+}
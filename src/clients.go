@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/polly"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/transcribeservice"
+	"github.com/aws/aws-sdk-go/service/translate"
+)
+
+// 使用する Translate の操作
+type TranslateAPI interface {
+	TextWithContext(aws.Context, *translate.TextInput, ...request.Option) (*translate.TextOutput, error)
+	ListLanguagesWithContext(aws.Context, *translate.ListLanguagesInput, ...request.Option) (*translate.ListLanguagesOutput, error)
+	ListTerminologiesWithContext(aws.Context, *translate.ListTerminologiesInput, ...request.Option) (*translate.ListTerminologiesOutput, error)
+}
+
+// 使用する Polly の操作
+type PollyAPI interface {
+	SynthesizeSpeechWithContext(aws.Context, *polly.SynthesizeSpeechInput, ...request.Option) (*polly.SynthesizeSpeechOutput, error)
+	DescribeVoicesWithContext(aws.Context, *polly.DescribeVoicesInput, ...request.Option) (*polly.DescribeVoicesOutput, error)
+	ListLexiconsWithContext(aws.Context, *polly.ListLexiconsInput, ...request.Option) (*polly.ListLexiconsOutput, error)
+}
+
+// 使用する S3 の操作
+type S3API interface {
+	GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error)
+	GetObjectRequest(*s3.GetObjectInput) (*request.Request, *s3.GetObjectOutput)
+	HeadObjectWithContext(aws.Context, *s3.HeadObjectInput, ...request.Option) (*s3.HeadObjectOutput, error)
+	HeadBucketWithContext(aws.Context, *s3.HeadBucketInput, ...request.Option) (*s3.HeadBucketOutput, error)
+	CreateBucketWithContext(aws.Context, *s3.CreateBucketInput, ...request.Option) (*s3.CreateBucketOutput, error)
+	ListObjectsV2WithContext(aws.Context, *s3.ListObjectsV2Input, ...request.Option) (*s3.ListObjectsV2Output, error)
+	DeleteObjectsWithContext(aws.Context, *s3.DeleteObjectsInput, ...request.Option) (*s3.DeleteObjectsOutput, error)
+	WaitUntilBucketExistsWithContext(aws.Context, *s3.HeadBucketInput, ...request.WaiterOption) error
+}
+
+// 使用する S3 へのアップロード操作（s3manager.Uploader）
+type UploaderAPI interface {
+	UploadWithContext(aws.Context, *s3manager.UploadInput, ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
+}
+
+// 使用する Transcribe の操作
+type TranscribeAPI interface {
+	StartTranscriptionJobWithContext(aws.Context, *transcribeservice.StartTranscriptionJobInput, ...request.Option) (*transcribeservice.StartTranscriptionJobOutput, error)
+	GetTranscriptionJobWithContext(aws.Context, *transcribeservice.GetTranscriptionJobInput, ...request.Option) (*transcribeservice.GetTranscriptionJobOutput, error)
+	DeleteTranscriptionJobWithContext(aws.Context, *transcribeservice.DeleteTranscriptionJobInput, ...request.Option) (*transcribeservice.DeleteTranscriptionJobOutput, error)
+	ListTranscriptionJobsWithContext(aws.Context, *transcribeservice.ListTranscriptionJobsInput, ...request.Option) (*transcribeservice.ListTranscriptionJobsOutput, error)
+	GetVocabularyWithContext(aws.Context, *transcribeservice.GetVocabularyInput, ...request.Option) (*transcribeservice.GetVocabularyOutput, error)
+}
+
+// 使用する STS の操作
+type STSAPI interface {
+	GetCallerIdentityWithContext(aws.Context, *sts.GetCallerIdentityInput, ...request.Option) (*sts.GetCallerIdentityOutput, error)
+}
+
+// --dynamodb-table の翻訳メモリーで使う DynamoDB の操作
+type DynamoDBAPI interface {
+	DescribeTableWithContext(aws.Context, *dynamodb.DescribeTableInput, ...request.Option) (*dynamodb.DescribeTableOutput, error)
+	GetItemWithContext(aws.Context, *dynamodb.GetItemInput, ...request.Option) (*dynamodb.GetItemOutput, error)
+	PutItemWithContext(aws.Context, *dynamodb.PutItemInput, ...request.Option) (*dynamodb.PutItemOutput, error)
+}
+
+// --sns-topic-arn の通知で使う SNS の操作
+type SNSAPI interface {
+	PublishWithContext(aws.Context, *sns.PublishInput, ...request.Option) (*sns.PublishOutput, error)
+}
+
+// パイプラインで使うAWSクライアント一式（テストではモックに差し替える）
+type Clients struct {
+	Translate  TranslateAPI
+	Polly      PollyAPI
+	S3         S3API
+	Uploader   UploaderAPI
+	Transcribe TranscribeAPI
+	STS        STSAPI
+	DynamoDB   DynamoDBAPI
+	SNS        SNSAPI
+}
+
+// セッションから実際のAWSクライアントを作成する
+func newClients(sess *session.Session) *Clients {
+	return &Clients{
+		Translate:  translate.New(sess),
+		Polly:      polly.New(sess),
+		S3:         s3.New(sess),
+		Uploader:   s3manager.NewUploader(sess),
+		Transcribe: transcribeservice.New(sess),
+		STS:        sts.New(sess),
+		DynamoDB:   dynamodb.New(sess),
+		SNS:        sns.New(sess),
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/polly"
+	"github.com/aws/aws-sdk-go/service/transcribeservice"
+	"github.com/aws/aws-sdk-go/service/translate"
+)
+
+// パイプラインの一部だけを行うサブコマンド
+type command struct {
+	name    string
+	summary string
+	// サブコマンド固有のフラグを登録する（なければ nil）
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context, clients *Clients, cfg *Config) error
+	// AWS を使わないコマンドは、セッションを作らずに clients を nil で run を呼ぶ
+	local bool
+}
+
+// サブコマンドの一覧
+func commands() []command {
+	return []command{
+		voicesCommand(),
+		languagesCommand(),
+		transcribeCommand(),
+		statusCommand(),
+		jobsCommand(),
+		cleanupCommand(),
+		translateCommand(),
+		synthesizeCommand(),
+		serveCommand(),
+		versionCommand(),
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// サブコマンドを実行し、終了コードを返す
+// フラグや設定ファイル、AWSへの接続の指定はパイプラインと共通
+func runCommand(cmd command, args []string) int {
+	cfg, err := parseFlags(args, func(fs *flag.FlagSet) {
+		if cmd.name == "" {
+			return
+		}
+		fs.Init(fs.Name()+" "+cmd.name, flag.ContinueOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "Usage: %s [options]\n\n%s\n\nOptions:\n", fs.Name(), cmd.summary)
+			fs.PrintDefaults()
+			fmt.Fprint(fs.Output(), envHelp+exitCodeHelp)
+		}
+		if cmd.flags != nil {
+			cmd.flags(fs)
+		}
+	})
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		fmt.Fprintln(os.Stderr, "Error parsing flags:", err)
+		return exitError
+	}
+	slog.SetDefault(newLogger(os.Stderr, cfg))
+	if cfg.ShowVersion {
+		printVersion(os.Stdout)
+		return exitOK
+	}
+
+	ctx, stop := withGracefulShutdown(context.Background(), cfg.ShutdownGrace)
+	defer stop()
+	if cfg.PprofAddr != "" {
+		stopPprof, err := startPprof(cfg.PprofAddr)
+		if err != nil {
+			slog.Error("starting pprof", "error", err)
+			return exitError
+		}
+		defer stopPprof()
+	}
+
+	var clients *Clients
+	if !cmd.local {
+		sess, err := newSession(ctx, cfg)
+		if err != nil {
+			slog.Error("creating AWS session", "error", err)
+			return exitAWS
+		}
+		clients = newClients(sess)
+	}
+	if err := cmd.run(ctx, clients, cfg); err != nil {
+		name := cmd.name
+		if name == "" {
+			name = "run"
+		}
+		slog.Error(name+" failed", "error", err)
+		return exitCodeFor(err)
+	}
+	return exitOK
+}
+
+// voices: リージョンで利用可能な Polly の音声を一覧表示する
+func voicesCommand() command {
+	var language string
+	return command{
+		name:    "voices",
+		summary: "List the Polly voices available in the region",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&language, "language", "", "only list voices for this language (e.g. en or en-GB)")
+		},
+		run: func(ctx context.Context, clients *Clients, cfg *Config) error {
+			catalog, err := loadVoiceCatalog(ctx, clients.Polly, cfg)
+			if err != nil {
+				return fmt.Errorf("describing Polly voices: %w", err)
+			}
+			var voices []*polly.Voice
+			for _, v := range catalog.voices {
+				if language == "" || pollyLanguageMatches(aws.StringValue(v.LanguageCode), language) {
+					voices = append(voices, v)
+				}
+			}
+			sort.Slice(voices, func(i, j int) bool {
+				if li, lj := aws.StringValue(voices[i].LanguageCode), aws.StringValue(voices[j].LanguageCode); li != lj {
+					return li < lj
+				}
+				return aws.StringValue(voices[i].Id) < aws.StringValue(voices[j].Id)
+			})
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VOICE ID\tLANGUAGE\tGENDER\tENGINES")
+			for _, v := range voices {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", aws.StringValue(v.Id), aws.StringValue(v.LanguageCode),
+					aws.StringValue(v.Gender), strings.Join(aws.StringValueSlice(v.SupportedEngines), ","))
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// languages: Translate が対応している言語を一覧表示する
+func languagesCommand() command {
+	var displayLanguage string
+	return command{
+		name:    "languages",
+		summary: "List the languages supported by Translate (codes for --source-lang and --target-lang)",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&displayLanguage, "display-language", "", "language code for the language names (e.g. ja); defaults to English")
+		},
+		run: func(ctx context.Context, clients *Clients, cfg *Config) error {
+			input := &translate.ListLanguagesInput{}
+			if displayLanguage != "" {
+				input.DisplayLanguageCode = aws.String(displayLanguage)
+			}
+			var languages []*translate.Language
+			for {
+				var output *translate.ListLanguagesOutput
+				err := withRetry(ctx, cfg, func() error {
+					callCtx, cancel := callContext(ctx, cfg.Timeout)
+					defer cancel()
+					var err error
+					output, err = clients.Translate.ListLanguagesWithContext(callCtx, input)
+					return err
+				})
+				var aerr awserr.Error
+				if errors.As(err, &aerr) && aerr.Code() == request.ErrCodeRequestError {
+					return fmt.Errorf("could not reach Translate in region %s (the region may not support Amazon Translate): %w", cfg.Region, err)
+				}
+				if err != nil {
+					return fmt.Errorf("listing Translate languages: %w", err)
+				}
+				languages = append(languages, output.Languages...)
+				if aws.StringValue(output.NextToken) == "" {
+					break
+				}
+				input.NextToken = output.NextToken
+			}
+			sort.Slice(languages, func(i, j int) bool {
+				return aws.StringValue(languages[i].LanguageCode) < aws.StringValue(languages[j].LanguageCode)
+			})
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "CODE\tNAME")
+			for _, l := range languages {
+				fmt.Fprintf(w, "%s\t%s\n", aws.StringValue(l.LanguageCode), aws.StringValue(l.LanguageName))
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// transcribe: S3にある既存の音声だけを文字起こしする
+func transcribeCommand() command {
+	var s3URI, key, languageCode, mediaFormat string
+	return command{
+		name:    "transcribe",
+		summary: "Transcribe existing audio in S3 and print the transcript (no Translate or Polly calls)",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&s3URI, "s3-uri", "", "audio to transcribe, as s3://bucket/key")
+			fs.StringVar(&key, "key", "", "key of the audio in --bucket (alternative to --s3-uri)")
+			fs.StringVar(&languageCode, "language-code", "en-US", "language of the audio (Transcribe language code; ignored with --transcribe-auto-detect)")
+			fs.StringVar(&mediaFormat, "media-format", "", "format of the audio: "+strings.Join(transcribeservice.MediaFormat_Values(), ", ")+" (default: from the file extension)")
+		},
+		run: func(ctx context.Context, clients *Clients, cfg *Config) error {
+			switch {
+			case s3URI != "" && key != "":
+				return errors.New("use either --s3-uri or --key, not both")
+			case s3URI == "" && key == "":
+				return errors.New("--s3-uri or --key is required")
+			case key != "":
+				s3URI = fmt.Sprintf("s3://%s/%s", cfg.Bucket, key)
+			case !strings.HasPrefix(s3URI, "s3://"):
+				return fmt.Errorf("--s3-uri must start with s3://, got %q", s3URI)
+			}
+			if mediaFormat == "" {
+				var err error
+				if mediaFormat, err = mediaFormatFor(s3URI); err != nil {
+					return fmt.Errorf("%w (use --media-format)", err)
+				}
+			}
+			if !slices.Contains(transcribeservice.MediaFormat_Values(), mediaFormat) {
+				return fmt.Errorf("--media-format must be one of %s, got %q", strings.Join(transcribeservice.MediaFormat_Values(), ", "), mediaFormat)
+			}
+
+			// --transcribe-auto-detect の場合は --language-code を使わず言語を判定させる
+			if cfg.TranscribeAutoDetect {
+				languageCode = ""
+			}
+			if !cfg.DryRun {
+				if err := checkVocabulary(ctx, clients.Transcribe, cfg, languageCode); err != nil {
+					return fmt.Errorf("checking Transcribe vocabulary: %w", err)
+				}
+			}
+			result, err := transcribeMedia(ctx, clients.Transcribe, cfg, transcriptionRequest{
+				MediaURI:     s3URI,
+				LanguageCode: languageCode,
+				MediaFormat:  mediaFormat,
+			})
+			if err != nil {
+				return fmt.Errorf("transcribing %s: %w", s3URI, err)
+			}
+			if cfg.DryRun {
+				slog.Info("[DRYRUN] would start transcription job", "job", result.JobName, "uri", s3URI)
+				return nil
+			}
+			slog.Info("transcription job completed", "job", result.JobName, "transcript_uri", result.TranscriptURI, "language", result.LanguageCode)
+			transcript, err := downloadTranscript(ctx, clients.S3, cfg, result.JobName)
+			if err != nil {
+				return fmt.Errorf("downloading transcript: %w", err)
+			}
+			slog.Info("wrote transcript text", "path", transcript.TextFile)
+			if transcript.SubtitleFile != "" {
+				slog.Info("wrote subtitles", "path", transcript.SubtitleFile)
+			}
+			fmt.Println(transcript.Text)
+			return nil
+		},
+	}
+}
+
+// status: 文字起こしジョブの状態を表示する（ジョブが失敗していれば終了コードを1にする）
+func statusCommand() command {
+	var jobName string
+	return command{
+		name:    "status",
+		summary: "Show the status of a transcription job and exit non-zero if it failed",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&jobName, "job-name", "", "name of the transcription job")
+		},
+		run: func(ctx context.Context, clients *Clients, cfg *Config) error {
+			if jobName == "" {
+				return errors.New("--job-name is required")
+			}
+			if cfg.DryRun {
+				slog.Info("[DRYRUN] would get transcription job", "job", jobName)
+				return nil
+			}
+			var output *transcribeservice.GetTranscriptionJobOutput
+			err := withRetry(ctx, cfg, func() error {
+				callCtx, cancel := callContext(ctx, cfg.Timeout)
+				defer cancel()
+				var err error
+				output, err = clients.Transcribe.GetTranscriptionJobWithContext(callCtx, &transcribeservice.GetTranscriptionJobInput{
+					TranscriptionJobName: aws.String(jobName),
+				})
+				return err
+			})
+			// 存在しないジョブは BadRequestException になる
+			var aerr awserr.Error
+			if errors.As(err, &aerr) && (aerr.Code() == transcribeservice.ErrCodeNotFoundException || aerr.Code() == transcribeservice.ErrCodeBadRequestException) {
+				return fmt.Errorf("transcription job %s was not found in region %s (jobs are kept for 90 days)", jobName, cfg.Region)
+			}
+			if err != nil {
+				return fmt.Errorf("getting transcription job %s: %w", jobName, err)
+			}
+
+			job := output.TranscriptionJob
+			status := aws.StringValue(job.TranscriptionJobStatus)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "Job:\t%s\n", aws.StringValue(job.TranscriptionJobName))
+			fmt.Fprintf(w, "Status:\t%s\n", status)
+			if lang := aws.StringValue(job.LanguageCode); lang != "" {
+				fmt.Fprintf(w, "Language:\t%s\n", lang)
+			}
+			if job.CreationTime != nil {
+				fmt.Fprintf(w, "Created:\t%s\n", job.CreationTime.Local().Format(time.RFC3339))
+			}
+			if job.CompletionTime != nil {
+				fmt.Fprintf(w, "Completed:\t%s\n", job.CompletionTime.Local().Format(time.RFC3339))
+			}
+			if job.Transcript != nil && job.Transcript.TranscriptFileUri != nil {
+				fmt.Fprintf(w, "Transcript:\t%s\n", aws.StringValue(job.Transcript.TranscriptFileUri))
+			}
+			if reason := aws.StringValue(job.FailureReason); reason != "" {
+				fmt.Fprintf(w, "Failure reason:\t%s\n", reason)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if status == transcribeservice.TranscriptionJobStatusFailed {
+				return fmt.Errorf("transcription job %s failed", jobName)
+			}
+			return nil
+		},
+	}
+}
+
+// jobs: 最近の文字起こしジョブを新しい順に一覧表示する
+func jobsCommand() command {
+	var status, prefix string
+	var maxJobs int
+	return command{
+		name:    "jobs",
+		summary: "List recent transcription jobs, newest first",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&status, "status", "", "only list jobs with this status: "+strings.Join(transcribeservice.TranscriptionJobStatus_Values(), ", "))
+			fs.StringVar(&prefix, "prefix", "", "only list jobs whose name starts with this prefix (e.g. the --job-prefix of a run)")
+			fs.IntVar(&maxJobs, "max", 50, "maximum number of jobs to list (0 lists all)")
+		},
+		run: func(ctx context.Context, clients *Clients, cfg *Config) error {
+			if status != "" && !slices.Contains(transcribeservice.TranscriptionJobStatus_Values(), status) {
+				return fmt.Errorf("--status must be one of %s, got %q", strings.Join(transcribeservice.TranscriptionJobStatus_Values(), ", "), status)
+			}
+			if maxJobs < 0 {
+				return errors.New("--max must not be negative")
+			}
+			if cfg.DryRun {
+				slog.Info("[DRYRUN] would list transcription jobs", "status", status, "prefix", prefix)
+				return nil
+			}
+			jobs, err := listTranscriptionJobs(ctx, clients.Transcribe, cfg, status, prefix, maxJobs)
+			if err != nil {
+				return fmt.Errorf("listing transcription jobs: %w", err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tSTATUS\tCREATED")
+			for _, job := range jobs {
+				created := ""
+				if job.CreationTime != nil {
+					created = job.CreationTime.Local().Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", aws.StringValue(job.TranscriptionJobName), aws.StringValue(job.TranscriptionJobStatus), created)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// cleanup: 繰り返しの実行で溜まった音声・結果JSONと文字起こしジョブを削除する
+func cleanupCommand() command {
+	var objects, jobs, confirm bool
+	return command{
+		name:    "cleanup",
+		summary: "Delete audio files and transcripts under --s3-prefix and transcription jobs named with --job-prefix",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&objects, "objects", false, "delete generated audio files and transcript JSONs under --s3-prefix and --transcribe-output-prefix")
+			fs.BoolVar(&jobs, "jobs", false, "delete finished transcription jobs whose names start with --job-prefix")
+			fs.BoolVar(&confirm, "confirm", false, "delete without asking for confirmation")
+		},
+		run: func(ctx context.Context, clients *Clients, cfg *Config) error {
+			if !objects && !jobs {
+				return errors.New("nothing to clean up: pass --objects and/or --jobs")
+			}
+			if cfg.DryRun {
+				slog.Info("[DRYRUN] would list and delete generated resources", "bucket", cfg.Bucket, "s3_prefix", cfg.S3Prefix, "job_prefix", cfg.JobPrefix, "objects", objects, "jobs", jobs)
+				return nil
+			}
+
+			var keys []string
+			if objects {
+				var err error
+				if keys, err = listGeneratedObjects(ctx, clients.S3, cfg); err != nil {
+					return fmt.Errorf("listing objects in %s: %w", cfg.Bucket, err)
+				}
+			}
+			// 実行中のジョブは削除せず、完了または失敗したジョブだけを対象にする
+			var finished []*transcribeservice.TranscriptionJobSummary
+			if jobs {
+				all, err := listTranscriptionJobs(ctx, clients.Transcribe, cfg, "", cfg.JobPrefix+"-", 0)
+				if err != nil {
+					return fmt.Errorf("listing transcription jobs: %w", err)
+				}
+				for _, job := range all {
+					switch aws.StringValue(job.TranscriptionJobStatus) {
+					case transcribeservice.TranscriptionJobStatusCompleted, transcribeservice.TranscriptionJobStatusFailed:
+						finished = append(finished, job)
+					}
+				}
+			}
+			if len(keys) == 0 && len(finished) == 0 {
+				fmt.Println("Nothing to clean up.")
+				return nil
+			}
+
+			fmt.Printf("Found %d objects in s3://%s/%s and %d transcription jobs named %s-*\n", len(keys), cfg.Bucket, cfg.S3Prefix, len(finished), cfg.JobPrefix)
+			if !confirm {
+				fmt.Print("Delete them? [y/N] ")
+				answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+				if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+					fmt.Println("Aborted.")
+					return nil
+				}
+			}
+
+			deletedObjects, objErr := deleteObjects(ctx, clients.S3, cfg, keys)
+			deletedJobs, jobErr := deleteTranscriptionJobs(ctx, clients.Transcribe, cfg, finished)
+			fmt.Printf("Deleted %d objects and %d transcription jobs\n", deletedObjects, deletedJobs)
+			return errors.Join(objErr, jobErr)
+		},
+	}
+}
+
+// translate: 翻訳だけを行い、翻訳結果のテキストファイルを書き出す（Polly・S3・Transcribe は使わない）
+func translateCommand() command {
+	return command{
+		name:    "translate",
+		summary: "Translate the input and write the translated text file only (no Polly, S3 or Transcribe calls)",
+		run: func(ctx context.Context, clients *Clients, cfg *Config) error {
+			return runPipeline(ctx, clients, cfg, modeTranslate)
+		},
+	}
+}
+
+// synthesize: 翻訳済みのテキストを音声合成してS3にアップロードする（Translate と Transcribe は使わない）
+func synthesizeCommand() command {
+	return command{
+		name:    "synthesize",
+		summary: "Synthesize already translated text with Polly and upload the audio to S3 (no Translate or Transcribe calls); --target-lang is the language of the text",
+		run: func(ctx context.Context, clients *Clients, cfg *Config) error {
+			if len(cfg.TargetLangs) != 1 {
+				return errors.New("synthesize reads text in a single language; set --target-lang to the language of the input instead of --target-langs")
+			}
+			return runPipeline(ctx, clients, cfg, modeSynthesize)
+		},
+	}
+}
+
+// serve: POST /synthesize で受け取ったテキストを翻訳・音声合成し、訳文と音声の期限付きURLを返すHTTPサーバー
+func serveCommand() command {
+	var addr string
+	return command{
+		name:    "serve",
+		summary: "Run an HTTP server whose POST /synthesize endpoint translates and synthesizes {text, sourceLang, targetLang, voice} and returns the translation and a presigned audio URL",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&addr, "addr", ":8080", "address to listen on")
+		},
+		run: func(ctx context.Context, clients *Clients, cfg *Config) error {
+			p, err := newPipeline(ctx, clients, cfg, modeServe)
+			if err != nil {
+				return err
+			}
+			p.report = func(res LineResult) { logLineResult(cfg, res) }
+			return serve(ctx, p, addr)
+		},
+	}
+}
@@ -0,0 +1,628 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/polly"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/translate"
+	"gopkg.in/yaml.v3"
+)
+
+// コマンドラインや --config の YAML ファイルから受け取る設定値
+// YAML のキーはフラグ名と同じ
+type Config struct {
+	ConfigPath  string `yaml:"-"`
+	ShowVersion bool   `yaml:"-"`
+
+	Profile       string        `yaml:"profile"`
+	AssumeRoleARN string        `yaml:"assume-role-arn"`
+	ExternalID    string        `yaml:"external-id"`
+	EndpointURL   string        `yaml:"endpoint-url"`
+	AWSDebug      bool          `yaml:"aws-debug"`
+	AWSDebugBody  bool          `yaml:"aws-debug-body"`
+	SDKMaxRetries int           `yaml:"sdk-max-retries"`
+	HTTPTimeout   time.Duration `yaml:"http-timeout"`
+	Proxy         string        `yaml:"proxy"`
+	proxy         *url.URL      // Proxy を解析したもの
+	NoProxy       []string      `yaml:"no-proxy"`
+	AccessKey     string        `yaml:"access-key"`
+	SecretKey     string        `yaml:"secret-key"`
+
+	Region                 string            `yaml:"region"`
+	Bucket                 string            `yaml:"bucket"`
+	CreateBucket           bool              `yaml:"create-bucket"`
+	S3Prefix               string            `yaml:"s3-prefix"`
+	TranscribeOutputPrefix string            `yaml:"transcribe-output-prefix"`
+	SSE                    string            `yaml:"sse"`
+	StorageClass           string            `yaml:"storage-class"`
+	Tags                   map[string]string `yaml:"tag"`
+	Force                  bool              `yaml:"force"`
+	Presign                bool              `yaml:"presign"`
+	PresignExpiry          time.Duration     `yaml:"presign-expiry"`
+	KMSKeyID               string            `yaml:"kms-key-id"`
+	InputPath              string            `yaml:"input"`
+	InputDir               string            `yaml:"input-dir"`
+	Recursive              bool              `yaml:"recursive"`
+	OutputDir              string            `yaml:"output-dir"`
+	InputFormat            string            `yaml:"format"`
+	CSVColumn              string            `yaml:"csv-column"`
+	CSVHeader              bool              `yaml:"csv-header"`
+	JSONField              string            `yaml:"json-field"`
+	MaxLineBytes           int               `yaml:"max-line-bytes"`
+	NormalizeWhitespace    bool              `yaml:"normalize-whitespace"`
+	CommentPrefix          string            `yaml:"comment-prefix"`
+	PreserveComments       bool              `yaml:"preserve-comments"`
+	Offset                 int               `yaml:"offset"`
+	Limit                  int               `yaml:"limit"`
+	Filter                 string            `yaml:"filter"`
+	FilterPassthrough      bool              `yaml:"filter-passthrough"`
+	filter                 *regexp.Regexp    // Filter をコンパイルしたもの
+	OutputPath             string            `yaml:"output"`
+	OutputToS3             bool              `yaml:"output-to-s3"`
+	SNSTopicARN            string            `yaml:"sns-topic-arn"`
+	SourceLang             string            `yaml:"source-lang"`
+	TargetLang             string            `yaml:"target-lang"`
+	TargetLangs            []string          `yaml:"target-langs"`
+	Terminologies          []string          `yaml:"terminology"`
+	Formality              string            `yaml:"formality"`
+	MaskProfanity          bool              `yaml:"mask-profanity"`
+	Strict                 bool              `yaml:"strict"`
+
+	TranslationCache string            `yaml:"translation-cache"`
+	DynamoDBTable    string            `yaml:"dynamodb-table"`
+	ProtectPattern   string            `yaml:"protect-pattern"`
+	protect          *regexp.Regexp    // ProtectPattern をコンパイルしたもの
+	Voice            string            `yaml:"voice"`
+	VoiceMap         map[string]string `yaml:"voice-map"`
+	Engine           string            `yaml:"engine"`
+	TextType         string            `yaml:"text-type"`
+	AudioFormat      string            `yaml:"audio-format"`
+	SampleRate       string            `yaml:"sample-rate"`
+	Lexicons         []string          `yaml:"lexicon"`
+	SpeechMarks      []string          `yaml:"speech-marks"`
+	SpeechMarksToS3  bool              `yaml:"speech-marks-to-s3"`
+	MergeAudio       bool              `yaml:"merge-audio"`
+	GapMS            int               `yaml:"gap-ms"`
+	Timeout          time.Duration     `yaml:"timeout"`
+	ShutdownGrace    time.Duration     `yaml:"shutdown-grace"`
+	EventsNDJSON     bool              `yaml:"events-ndjson"`
+	PprofAddr        string            `yaml:"pprof-addr"`
+
+	MaxRetries     int           `yaml:"max-retries"`
+	RetryBaseDelay time.Duration `yaml:"retry-base-delay"`
+
+	PollInterval              time.Duration `yaml:"poll-interval"`
+	TranscribeTimeout         time.Duration `yaml:"transcribe-timeout"`
+	JobPrefix                 string        `yaml:"job-prefix"`
+	Subtitles                 string        `yaml:"subtitles"`
+	SubtitleMaxChars          int           `yaml:"subtitle-max-chars"`
+	MinConfidence             float64       `yaml:"min-confidence"`
+	VocabularyName            string        `yaml:"vocabulary-name"`
+	TranscribeLanguage        string        `yaml:"transcribe-language"`
+	TranscribeAutoDetect      bool          `yaml:"transcribe-auto-detect"`
+	TranscribeLanguageOptions []string      `yaml:"transcribe-language-options"`
+	ShowSpeakers              bool          `yaml:"show-speakers"`
+	MaxSpeakers               int           `yaml:"max-speakers"`
+	ChannelIdentification     bool          `yaml:"channel-identification"`
+	SimilarityNormalize       []string      `yaml:"similarity-normalize"`
+	BackTranslate             bool          `yaml:"back-translate"`
+
+	DryRun          bool   `yaml:"dry-run"`
+	ContinueOnError bool   `yaml:"continue-on-error"`
+	KeepAudio       bool   `yaml:"keep-audio"`
+	AudioDir        string `yaml:"audio-dir"`
+	ManifestPath    string `yaml:"manifest"`
+	Checkpoint      string `yaml:"checkpoint"`
+
+	Concurrency  int     `yaml:"concurrency"`
+	StageBuffer  int     `yaml:"stage-buffer"`
+	TranslateRPS float64 `yaml:"translate-rps"`
+
+	TranslateRate  float64 `yaml:"translate-rate"`
+	PollyRate      float64 `yaml:"polly-rate"`
+	TranscribeRate float64 `yaml:"transcribe-rate"`
+
+	LogLevel  string `yaml:"log-level"`
+	LogFormat string `yaml:"log-format"`
+}
+
+// 言語コードの形式（ja, en, zh-TW など）
+var languageCodePattern = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+
+// Transcribe のジョブ名に使える文字
+var jobNamePattern = regexp.MustCompile(`^[0-9a-zA-Z._-]{1,150}$`)
+
+// Transcribe の OutputKey に使える文字
+var transcribeOutputKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9\-_.!*'()/]+$`)
+
+// カンマ区切りで複数の値を受け取るフラグ
+type commaList []string
+
+func (l *commaList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *commaList) Set(value string) error {
+	*l = nil
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// 繰り返し指定できるフラグ（カンマ区切りも受け付け、設定ファイルの値に追加する）
+type repeatedList []string
+
+func (l *repeatedList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *repeatedList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" && !slices.Contains(*l, v) {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// カンマ区切りの key=value を受け取るフラグ（設定ファイルの値に追加・上書きする）
+type stringMap map[string]string
+
+func (m *stringMap) String() string {
+	pairs := make([]string, 0, len(*m))
+	for k, v := range *m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m *stringMap) Set(value string) error {
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" || strings.TrimSpace(v) == "" {
+			return fmt.Errorf("expected key=value, got %q", pair)
+		}
+		(*m)[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return nil
+}
+
+// コマンドライン引数を解析する（未指定の項目は従来の値を既定値とする）
+// 優先順位はフラグ、--config の YAML ファイル、既定値の順
+// extra はサブコマンド固有のフラグを追加する（なければ nil）
+func parseFlags(args []string, extra func(*flag.FlagSet)) (*Config, error) {
+	newFlags := func(cfg *Config) *flag.FlagSet {
+		fs := newFlagSet(cfg)
+		if extra != nil {
+			extra(fs)
+		}
+		documentEnv(fs)
+		return fs
+	}
+
+	// --config の指定を知るために一度解析する（フラグの誤りやヘルプもここで扱う）
+	pre := &Config{}
+	preFlags := newFlags(pre)
+	if err := applyEnv(preFlags); err != nil {
+		return nil, err
+	}
+	if err := preFlags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	fs := newFlags(cfg)
+	if pre.ConfigPath != "" {
+		if err := loadConfigFile(pre.ConfigPath, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(fs); err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if cfg.ExternalID != "" && cfg.AssumeRoleARN == "" {
+		return nil, errors.New("--external-id requires --assume-role-arn")
+	}
+	if cfg.AWSDebugBody && !cfg.AWSDebug {
+		return nil, errors.New("--aws-debug-body requires --aws-debug")
+	}
+	if cfg.SDKMaxRetries < aws.UseServiceDefaultRetries {
+		return nil, errors.New("--sdk-max-retries must be -1 or more")
+	}
+	if cfg.HTTPTimeout < 0 {
+		return nil, errors.New("--http-timeout must not be negative")
+	}
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("--proxy must be a URL such as http://proxy.example.com:8080, got %q", cfg.Proxy)
+		}
+		if proxy.Scheme != "http" && proxy.Scheme != "https" && proxy.Scheme != "socks5" {
+			return nil, fmt.Errorf("--proxy scheme must be http, https or socks5, got %q", proxy.Scheme)
+		}
+		cfg.proxy = proxy
+	}
+	if (cfg.AccessKey == "") != (cfg.SecretKey == "") {
+		return nil, errors.New("--access-key and --secret-key must be given together")
+	}
+	if strings.TrimSpace(cfg.Bucket) == "" {
+		return nil, errors.New("--bucket must not be empty")
+	}
+	if cfg.SourceLang != autoDetectLanguage {
+		if err := validateLanguageCode(cfg.SourceLang); err != nil {
+			return nil, fmt.Errorf("--source-lang: %w", err)
+		}
+	}
+	if cfg.Formality != "" && !slices.Contains(translate.Formality_Values(), cfg.Formality) {
+		return nil, fmt.Errorf("--formality must be %q or %q, got %q", translate.FormalityFormal, translate.FormalityInformal, cfg.Formality)
+	}
+	if cfg.Engine != polly.EngineStandard && cfg.Engine != polly.EngineNeural {
+		return nil, fmt.Errorf("--engine must be %q or %q, got %q", polly.EngineStandard, polly.EngineNeural, cfg.Engine)
+	}
+	if cfg.TextType != polly.TextTypeText && cfg.TextType != polly.TextTypeSsml {
+		return nil, fmt.Errorf("--text-type must be %q or %q, got %q", polly.TextTypeText, polly.TextTypeSsml, cfg.TextType)
+	}
+	switch cfg.InputFormat {
+	case inputFormatText, inputFormatCSV, inputFormatJSON:
+	default:
+		return nil, fmt.Errorf("--format must be one of txt, csv or json, got %q", cfg.InputFormat)
+	}
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		return nil, err
+	}
+	if cfg.LogFormat != logFormatText && cfg.LogFormat != logFormatJSON {
+		return nil, fmt.Errorf("--log-format must be %q or %q, got %q", logFormatText, logFormatJSON, cfg.LogFormat)
+	}
+	cfg.S3Prefix = normalizeS3Prefix(cfg.S3Prefix)
+	// 文字起こしの結果JSONは、指定がなければ音声と同じプレフィックスの下に置く
+	if cfg.TranscribeOutputPrefix == "" {
+		cfg.TranscribeOutputPrefix = cfg.S3Prefix
+	} else {
+		cfg.TranscribeOutputPrefix = normalizeS3Prefix(cfg.TranscribeOutputPrefix)
+	}
+	if cfg.TranscribeOutputPrefix != "" && !transcribeOutputKeyPattern.MatchString(cfg.TranscribeOutputPrefix) {
+		return nil, fmt.Errorf("--transcribe-output-prefix %q may only contain letters, digits, slashes and - _ . ! * ' ( )", cfg.TranscribeOutputPrefix)
+	}
+	switch cfg.SSE {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+	default:
+		return nil, fmt.Errorf("--sse must be %q or %q, got %q", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms, cfg.SSE)
+	}
+	if cfg.SNSTopicARN != "" {
+		if parsed, err := arn.Parse(cfg.SNSTopicARN); err != nil || parsed.Service != sns.ServiceName {
+			return nil, fmt.Errorf("--sns-topic-arn must be an SNS topic ARN, got %q", cfg.SNSTopicARN)
+		}
+	}
+	if err := validateTags(cfg.Tags); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(s3.StorageClass_Values(), cfg.StorageClass) {
+		return nil, fmt.Errorf("--storage-class must be one of %s, got %q", strings.Join(s3.StorageClass_Values(), ", "), cfg.StorageClass)
+	}
+	if cfg.Presign && (cfg.PresignExpiry <= 0 || cfg.PresignExpiry > 7*24*time.Hour) {
+		return nil, errors.New("--presign-expiry must be between 1s and 168h")
+	}
+	if cfg.KMSKeyID != "" && cfg.SSE != s3.ServerSideEncryptionAwsKms {
+		return nil, fmt.Errorf("--kms-key-id requires --sse %s", s3.ServerSideEncryptionAwsKms)
+	}
+	if cfg.Concurrency < 1 {
+		return nil, errors.New("--concurrency must be at least 1")
+	}
+	if cfg.StageBuffer < 0 {
+		return nil, errors.New("--stage-buffer must not be negative")
+	}
+	if cfg.TranslateRPS < 0 {
+		return nil, errors.New("--translate-rps must not be negative")
+	}
+	if cfg.TranslateRate < 0 || cfg.PollyRate < 0 || cfg.TranscribeRate < 0 {
+		return nil, errors.New("--translate-rate, --polly-rate and --transcribe-rate must not be negative")
+	}
+	if cfg.PprofAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.PprofAddr); err != nil {
+			return nil, fmt.Errorf("invalid --pprof-addr %q: %w", cfg.PprofAddr, err)
+		}
+	}
+	if cfg.ShutdownGrace < 0 {
+		return nil, errors.New("--shutdown-grace must not be negative")
+	}
+	if cfg.MaxRetries < 0 {
+		return nil, errors.New("--max-retries must not be negative")
+	}
+	if !jobNamePattern.MatchString(cfg.JobPrefix) {
+		return nil, fmt.Errorf("--job-prefix %q may only contain letters, digits, '.', '_' and '-'", cfg.JobPrefix)
+	}
+	if cfg.Subtitles != "" && cfg.Subtitles != subtitleSRT && cfg.Subtitles != subtitleVTT {
+		return nil, fmt.Errorf("--subtitles must be %q or %q, got %q", subtitleSRT, subtitleVTT, cfg.Subtitles)
+	}
+	if cfg.SubtitleMaxChars < 1 {
+		return nil, errors.New("--subtitle-max-chars must be at least 1")
+	}
+	if cfg.MinConfidence < 0 || cfg.MinConfidence > 1 {
+		return nil, errors.New("--min-confidence must be between 0 and 1")
+	}
+	if cfg.TranscribeAutoDetect && cfg.TranscribeLanguage != "" {
+		return nil, errors.New("--transcribe-language cannot be combined with --transcribe-auto-detect")
+	}
+	if len(cfg.TranscribeLanguageOptions) > 0 && !cfg.TranscribeAutoDetect {
+		return nil, errors.New("--transcribe-language-options requires --transcribe-auto-detect")
+	}
+	if len(cfg.TranscribeLanguageOptions) == 1 {
+		return nil, errors.New("--transcribe-language-options needs at least two language codes")
+	}
+	if cfg.TranscribeAutoDetect && cfg.VocabularyName != "" {
+		return nil, errors.New("--vocabulary-name cannot be combined with --transcribe-auto-detect")
+	}
+	if cfg.ProtectPattern != "" {
+		var err error
+		if cfg.protect, err = regexp.Compile(cfg.ProtectPattern); err != nil {
+			return nil, fmt.Errorf("--protect-pattern: %w", err)
+		}
+	}
+	if cfg.Filter != "" {
+		var err error
+		if cfg.filter, err = regexp.Compile(cfg.Filter); err != nil {
+			return nil, fmt.Errorf("--filter: %w", err)
+		}
+	}
+	if cfg.FilterPassthrough && cfg.Filter == "" {
+		return nil, errors.New("--filter-passthrough requires --filter")
+	}
+	if cfg.Offset < 0 {
+		return nil, errors.New("--offset must not be negative")
+	}
+	if cfg.Limit < 0 {
+		return nil, errors.New("--limit must not be negative")
+	}
+	if cfg.MaxLineBytes < 1 {
+		return nil, errors.New("--max-line-bytes must be at least 1")
+	}
+	if cfg.PreserveComments && cfg.CommentPrefix == "" {
+		return nil, errors.New("--preserve-comments requires --comment-prefix")
+	}
+	if cfg.MaxSpeakers < 2 || cfg.MaxSpeakers > 30 {
+		return nil, errors.New("--max-speakers must be between 2 and 30")
+	}
+	if cfg.ShowSpeakers && cfg.ChannelIdentification {
+		return nil, errors.New("--show-speakers cannot be combined with --channel-identification")
+	}
+	for _, opt := range cfg.SimilarityNormalize {
+		if opt != normalizeLowercase && opt != normalizePunctuation && opt != normalizeNone {
+			return nil, fmt.Errorf("--similarity-normalize must be %q, %q or %q, got %q", normalizeLowercase, normalizePunctuation, normalizeNone, opt)
+		}
+	}
+	if cfg.PollInterval <= 0 {
+		return nil, errors.New("--poll-interval must be positive")
+	}
+	if _, ok := audioFormats[cfg.AudioFormat]; !ok {
+		return nil, fmt.Errorf("--audio-format must be one of mp3, ogg_vorbis, pcm or wav, got %q", cfg.AudioFormat)
+	}
+	for _, markType := range cfg.SpeechMarks {
+		if !slices.Contains(polly.SpeechMarkType_Values(), markType) {
+			return nil, fmt.Errorf("--speech-marks must be one of %s, got %q", strings.Join(polly.SpeechMarkType_Values(), ", "), markType)
+		}
+		if markType == polly.SpeechMarkTypeSsml && cfg.TextType != polly.TextTypeSsml {
+			return nil, errors.New("--speech-marks ssml requires --text-type ssml")
+		}
+	}
+	if cfg.SpeechMarksToS3 && len(cfg.SpeechMarks) == 0 {
+		return nil, errors.New("--speech-marks-to-s3 requires --speech-marks")
+	}
+	if cfg.GapMS < 0 {
+		return nil, errors.New("--gap-ms must not be negative")
+	}
+	if cfg.MergeAudio && cfg.GapMS > 0 && cfg.AudioFormat == polly.OutputFormatOggVorbis {
+		return nil, errors.New("--merge-audio cannot insert silence into ogg_vorbis audio; use --gap-ms 0 or another --audio-format")
+	}
+	if len(cfg.Lexicons) > maxLexicons {
+		return nil, fmt.Errorf("at most %d --lexicon names can be applied, got %d", maxLexicons, len(cfg.Lexicons))
+	}
+	// WAV のヘッダーにはサンプルレートが必要なため、省略時も pcm の既定値を明示する
+	if cfg.AudioFormat == audioFormatWav && cfg.SampleRate == "" {
+		cfg.SampleRate = "16000"
+	}
+	if rates := pollySampleRates[pollyOutputFormat(cfg.AudioFormat)]; cfg.SampleRate != "" && !slices.Contains(rates, cfg.SampleRate) {
+		return nil, fmt.Errorf("--sample-rate for --audio-format %s must be one of %s, got %q", cfg.AudioFormat, strings.Join(rates, ", "), cfg.SampleRate)
+	}
+	// フラグの --target-lang は設定ファイルの target-langs より優先する
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	if len(cfg.TargetLangs) == 0 || (setFlags["target-lang"] && !setFlags["target-langs"]) {
+		cfg.TargetLangs = []string{cfg.TargetLang}
+	}
+	seen := make(map[string]bool)
+	for _, lang := range cfg.TargetLangs {
+		if err := validateLanguageCode(lang); err != nil {
+			return nil, fmt.Errorf("target language: %w", err)
+		}
+		if seen[lang] {
+			return nil, fmt.Errorf("target language %q is specified more than once", lang)
+		}
+		seen[lang] = true
+	}
+	return cfg, nil
+}
+
+// S3のキープレフィックスを先頭のスラッシュなし・末尾のスラッシュ1つの形にそろえる（空ならバケット直下）
+func normalizeS3Prefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// 言語コードの形式を検証する（AWSを呼び出す前に明らかな誤りを弾く）
+func validateLanguageCode(code string) error {
+	if code == "" {
+		return errors.New("language code must not be empty")
+	}
+	if !languageCodePattern.MatchString(code) {
+		return fmt.Errorf("malformed language code %q (expected e.g. ja, en or zh-TW)", code)
+	}
+	return nil
+}
+
+// YAML の設定ファイルを cfg に読み込む（ファイルにない項目は cfg の値のまま）
+// 未知のキーや型の誤りはエラーにする
+func loadConfigFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening config file: %w", err)
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// フラグ定義をまとめたFlagSetを作成する
+func newFlagSet(cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	fs.StringVar(&cfg.ConfigPath, "config", "", "YAML file with default settings (keys are flag names; flags override it)")
+	fs.BoolVar(&cfg.ShowVersion, "version", false, "print the version and exit")
+	fs.StringVar(&cfg.Region, "region", "ap-northeast-1", "AWS region")
+	fs.StringVar(&cfg.Profile, "profile", "", "AWS named profile from the shared config and credentials files")
+	fs.StringVar(&cfg.AssumeRoleARN, "assume-role-arn", "", "IAM role to assume for all AWS calls (e.g. for a bucket in another account)")
+	fs.StringVar(&cfg.ExternalID, "external-id", "", "external ID to pass when assuming --assume-role-arn")
+	fs.StringVar(&cfg.EndpointURL, "endpoint-url", "", "override the endpoint for all AWS services (e.g. http://localhost:4566 for LocalStack); uses path-style S3 addressing")
+	fs.BoolVar(&cfg.AWSDebug, "aws-debug", false, "log every AWS request with its signing details, retries and errors to stderr, whatever the --log-level")
+	fs.BoolVar(&cfg.AWSDebugBody, "aws-debug-body", false, "with --aws-debug, also log HTTP request and response bodies (including audio data)")
+	fs.IntVar(&cfg.SDKMaxRetries, "sdk-max-retries", aws.UseServiceDefaultRetries, "retries the AWS SDK makes for each request before --max-retries applies (-1 uses each service's default)")
+	fs.DurationVar(&cfg.HTTPTimeout, "http-timeout", 0, "timeout for each HTTP request to AWS, including uploading the body (0 disables)")
+	fs.StringVar(&cfg.Proxy, "proxy", "", "HTTP(S) proxy URL for all AWS requests (default: HTTPS_PROXY and HTTP_PROXY)")
+	fs.Var((*commaList)(&cfg.NoProxy), "no-proxy", "comma-separated hosts, domains (.example.com) or CIDR ranges to reach without the proxy")
+	fs.StringVar(&cfg.AccessKey, "access-key", "", "static AWS access key ID (e.g. a dummy key for LocalStack)")
+	fs.StringVar(&cfg.SecretKey, "secret-key", "", "static AWS secret access key, used with --access-key")
+	fs.StringVar(&cfg.Bucket, "bucket", "report.3q-aws-s24745201.com", "S3 bucket for audio files and transcripts")
+	fs.BoolVar(&cfg.CreateBucket, "create-bucket", false, "create --bucket in --region if it does not exist")
+	fs.StringVar(&cfg.S3Prefix, "s3-prefix", "", "key prefix for uploaded audio and transcripts (e.g. runs/2024-06)")
+	fs.StringVar(&cfg.TranscribeOutputPrefix, "transcribe-output-prefix", "", "key prefix for Transcribe output JSON, stored as <prefix>/<job name>.json (default: --s3-prefix)")
+	fs.StringVar(&cfg.SSE, "sse", "", "server-side encryption for uploaded audio: AES256 or aws:kms")
+	fs.StringVar(&cfg.KMSKeyID, "kms-key-id", "", "KMS key for --sse aws:kms (also encrypts the Transcribe output)")
+	fs.StringVar(&cfg.StorageClass, "storage-class", s3.StorageClassStandard, "S3 storage class for uploaded audio (e.g. STANDARD_IA, INTELLIGENT_TIERING)")
+	fs.Var((*stringMap)(&cfg.Tags), "tag", "comma-separated key=value S3 object tags for uploaded audio, added to the source-lang, target-lang and voice tags (repeatable)")
+	fs.BoolVar(&cfg.Force, "force", false, "synthesize and upload audio even if the same audio already exists in S3")
+	fs.BoolVar(&cfg.Presign, "presign", false, "generate a presigned download URL for each uploaded audio file")
+	fs.DurationVar(&cfg.PresignExpiry, "presign-expiry", 24*time.Hour, "validity of --presign URLs (at most 7 days)")
+	fs.StringVar(&cfg.InputPath, "input", "./input.txt", "path to the input text file (\"-\" reads from stdin)")
+	fs.StringVar(&cfg.InputDir, "input-dir", "", "process every input file (matching --format) in this directory instead of --input")
+	fs.BoolVar(&cfg.Recursive, "recursive", false, "walk --input-dir recursively")
+	fs.StringVar(&cfg.OutputDir, "output-dir", "translated", "directory for translated files when --input-dir is used (keeps relative paths)")
+	fs.StringVar(&cfg.InputFormat, "format", inputFormatText, "input format: txt, csv or json")
+	fs.StringVar(&cfg.CSVColumn, "csv-column", "0", "CSV column holding the text, as a zero-based index or a header name")
+	fs.BoolVar(&cfg.CSVHeader, "csv-header", false, "treat the first CSV row as a header and skip it")
+	fs.StringVar(&cfg.JSONField, "json-field", "", "field holding the text when the JSON input is an array of objects")
+	fs.StringVar(&cfg.Filter, "filter", "", "only process input lines matching this regular expression")
+	fs.BoolVar(&cfg.FilterPassthrough, "filter-passthrough", false, "copy lines not matching --filter verbatim into the translated output instead of leaving them blank")
+	fs.IntVar(&cfg.Offset, "offset", 0, "skip the first N lines of each input file (blank and comment lines are not counted)")
+	fs.IntVar(&cfg.Limit, "limit", 0, "process at most N lines of each input file after --offset (0 processes all)")
+	fs.BoolVar(&cfg.NormalizeWhitespace, "normalize-whitespace", false, "trim each input line and collapse runs of whitespace (including full-width spaces) into one space")
+	fs.IntVar(&cfg.MaxLineBytes, "max-line-bytes", 1<<20, "maximum length of a line in a txt input file, in bytes")
+	fs.StringVar(&cfg.CommentPrefix, "comment-prefix", "", "skip input lines starting with this prefix (e.g. #) instead of translating them")
+	fs.BoolVar(&cfg.PreserveComments, "preserve-comments", false, "copy --comment-prefix lines verbatim into the translated output instead of leaving them blank")
+	fs.StringVar(&cfg.OutputPath, "output", "translated_text.txt", "path to the translated text file")
+	fs.BoolVar(&cfg.OutputToS3, "output-to-s3", false, "also upload each translated text file and the --manifest to the bucket under --s3-prefix")
+	fs.StringVar(&cfg.SNSTopicARN, "sns-topic-arn", "", "SNS topic (in --region) to notify with a summary when the run finishes or fails")
+	fs.StringVar(&cfg.SourceLang, "source-lang", "ja", "source language code for Translate (\"auto\" to detect per line)")
+	fs.StringVar(&cfg.TargetLang, "target-lang", "en", "target language code for Translate")
+	fs.Var((*commaList)(&cfg.Terminologies), "terminology", "comma-separated Translate custom terminology names to apply")
+	fs.StringVar(&cfg.Formality, "formality", "", "Translate formality: FORMAL or INFORMAL (for supported target languages)")
+	fs.BoolVar(&cfg.MaskProfanity, "mask-profanity", false, "mask profane words in translations")
+	fs.StringVar(&cfg.ProtectPattern, "protect-pattern", "", "regular expression for tokens to keep untranslated (e.g. \\{[^}]*\\}|%[sd] for {username} and %s)")
+	fs.BoolVar(&cfg.Strict, "strict", false, "fail instead of retrying without --formality when a language pair does not support it, or of waiting for a pending --vocabulary-name, or of skipping an unavailable --dynamodb-table")
+	fs.StringVar(&cfg.TranslationCache, "translation-cache", "", "JSON file to keep translations between runs (duplicate lines are always translated once per run)")
+	fs.StringVar(&cfg.DynamoDBTable, "dynamodb-table", "", "DynamoDB table to share translations across runs and machines (partition key text_hash and sort key lang_pair, both strings); skipped with a warning if unavailable unless --strict")
+	fs.StringVar(&cfg.Voice, "voice", "", "Polly voice ID for all target languages (default: Joanna for en, a per-language voice otherwise)")
+	fs.Var((*stringMap)(&cfg.VoiceMap), "voice-map", "comma-separated language=voice pairs (e.g. en=Matthew,de=Hans); other languages get a voice chosen from DescribeVoices")
+	fs.StringVar(&cfg.Engine, "engine", polly.EngineStandard, "Polly engine: standard or neural")
+	fs.StringVar(&cfg.TextType, "text-type", polly.TextTypeText, "Polly input text type: text or ssml")
+	fs.BoolVar(&cfg.ContinueOnError, "continue-on-error", false, "record failed lines in "+failuresFileName+" and keep processing the rest")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "validate input and configuration without calling AWS")
+	fs.BoolVar(&cfg.KeepAudio, "keep-audio", false, "keep a local copy of each synthesized audio file")
+	fs.StringVar(&cfg.Checkpoint, "checkpoint", "", "record completed lines in this file and skip them when the run is repeated after an interruption")
+	fs.StringVar(&cfg.ManifestPath, "manifest", "", "write a JSON manifest of every processed line to this file")
+	fs.StringVar(&cfg.AudioDir, "audio-dir", ".", "directory for local audio files kept with --keep-audio and --speech-marks files")
+	fs.IntVar(&cfg.Concurrency, "concurrency", 4, "number of lines processed in parallel by each stage (translate, synthesize, transcribe)")
+	fs.IntVar(&cfg.StageBuffer, "stage-buffer", 16, "number of lines buffered between stages")
+	fs.Float64Var(&cfg.TranslateRPS, "translate-rps", 10, "maximum Translate requests per second shared by all workers (0 disables; Polly and Transcribe have separate limits)")
+	fs.Float64Var(&cfg.TranslateRate, "translate-rate", 0.000015, "Translate price in USD per character, for the cost estimate")
+	fs.Float64Var(&cfg.PollyRate, "polly-rate", 0.000004, "Polly price in USD per character, for the cost estimate (neural voices cost more)")
+	fs.Float64Var(&cfg.TranscribeRate, "transcribe-rate", 0.0004, "Transcribe price in USD per second of audio, for the cost estimate")
+	fs.DurationVar(&cfg.Timeout, "timeout", time.Minute, "timeout for each AWS API call (0 disables)")
+	fs.DurationVar(&cfg.ShutdownGrace, "shutdown-grace", 30*time.Second, "on interrupt, how long to wait for lines in progress before cancelling them (interrupt again to exit immediately)")
+	fs.BoolVar(&cfg.EventsNDJSON, "events-ndjson", false, "write one JSON object per line to stdout for each progress event (line started, translated, uploaded, job started and completed); summaries then go to stderr")
+	fs.StringVar(&cfg.PprofAddr, "pprof-addr", "", "serve net/http/pprof profiles on this address while running (e.g. localhost:6060; off by default)")
+	fs.IntVar(&cfg.MaxRetries, "max-retries", 3, "maximum retries for throttled Translate/Polly/Transcribe calls")
+	fs.DurationVar(&cfg.RetryBaseDelay, "retry-base-delay", 200*time.Millisecond, "base delay for exponential backoff between retries")
+	fs.DurationVar(&cfg.PollInterval, "poll-interval", 5*time.Second, "interval between transcription job status checks")
+	fs.StringVar(&cfg.JobPrefix, "job-prefix", "transcription-job", "prefix for transcription job names")
+	fs.StringVar(&cfg.Subtitles, "subtitles", "", "also write subtitles from the transcript timestamps: srt or vtt")
+	fs.IntVar(&cfg.SubtitleMaxChars, "subtitle-max-chars", 42, "maximum characters per subtitle cue")
+	fs.Float64Var(&cfg.MinConfidence, "min-confidence", 0, "mark transcribed words below this confidence (0-1) as [word] and warn about lines whose average is lower (0 disables)")
+	fs.StringVar(&cfg.TranscribeLanguage, "transcribe-language", "", "Transcribe language code for the synthesized audio (default: derived from each target language, e.g. en -> en-US)")
+	fs.BoolVar(&cfg.TranscribeAutoDetect, "transcribe-auto-detect", false, "let Transcribe identify the spoken language instead of using a fixed language code")
+	fs.Var((*commaList)(&cfg.TranscribeLanguageOptions), "transcribe-language-options", "comma-separated candidate language codes for --transcribe-auto-detect (e.g. en-US,ja-JP)")
+	fs.StringVar(&cfg.VocabularyName, "vocabulary-name", "", "Transcribe custom vocabulary to use for transcription jobs (must be READY and match the audio language)")
+	fs.BoolVar(&cfg.ShowSpeakers, "show-speakers", false, "identify speakers in transcription jobs and prefix each turn in the transcript with its speaker label")
+	fs.IntVar(&cfg.MaxSpeakers, "max-speakers", 2, "maximum number of speakers to identify with --show-speakers (2-30)")
+	fs.BoolVar(&cfg.ChannelIdentification, "channel-identification", false, "transcribe each audio channel separately (cannot be combined with --show-speakers)")
+	cfg.SimilarityNormalize = []string{normalizeLowercase, normalizePunctuation}
+	fs.BoolVar(&cfg.BackTranslate, "back-translate", false, "translate each transcript back to the source language and score it against the input line")
+	fs.Var((*commaList)(&cfg.SimilarityNormalize), "similarity-normalize", "comma-separated normalization before scoring the transcript against the translation: lowercase, punctuation or none")
+	fs.DurationVar(&cfg.TranscribeTimeout, "transcribe-timeout", 10*time.Minute, "maximum time to wait for a transcription job (0 waits indefinitely)")
+	fs.StringVar(&cfg.AudioFormat, "audio-format", polly.OutputFormatMp3, "Polly output format: mp3, ogg_vorbis, pcm or wav (pcm with a WAV header)")
+	fs.Var((*repeatedList)(&cfg.Lexicons), "lexicon", "Polly pronunciation lexicon to apply (repeatable, at most 5)")
+	fs.Var((*commaList)(&cfg.SpeechMarks), "speech-marks", "comma-separated Polly speech mark types to write next to each audio file: word, sentence, viseme or ssml")
+	fs.BoolVar(&cfg.SpeechMarksToS3, "speech-marks-to-s3", false, "also upload the --speech-marks files to the bucket")
+	fs.BoolVar(&cfg.MergeAudio, "merge-audio", false, "also upload one audio file per input file and target language that joins the lines in order (ogg_vorbis gives chained streams that some players stop after the first)")
+	fs.IntVar(&cfg.GapMS, "gap-ms", 500, "milliseconds of silence between lines in --merge-audio (mp3, pcm and wav only)")
+	fs.StringVar(&cfg.SampleRate, "sample-rate", "", "Polly sample rate in Hz: 8000, 16000, 22050 or 24000 for mp3 and ogg_vorbis, 8000 or 16000 for pcm and wav (default depends on the format and engine)")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "log level: debug, info, warn or error (logs go to stderr)")
+	fs.StringVar(&cfg.LogFormat, "log-format", logFormatText, "log format: text or json")
+	fs.Var((*commaList)(&cfg.TargetLangs), "target-langs", "comma-separated target language codes, overrides --target-lang (e.g. en,de,fr)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [command] [options]\n\nCommands:\n", fs.Name())
+		for _, cmd := range commands() {
+			fmt.Fprintf(fs.Output(), "  %-12s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Fprintf(fs.Output(), "\nWithout a command, runs the full translate, synthesize and transcribe pipeline.\n\nOptions:\n")
+		fs.PrintDefaults()
+		fmt.Fprint(fs.Output(), envHelp+exitCodeHelp)
+	}
+	return fs
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Transcribe が1ジョブあたりに課金する最短の秒数
+const minTranscribeSeconds = 15
+
+// 音声の長さを文字数から見積もるときの、1秒あたりに読み上げる文字数
+const speechCharactersPerSecond = 15
+
+// 1回の実行でかかる料金の概算
+type costEstimate struct {
+	TranslateCharacters int64   `json:"translate_characters"`
+	PollyCharacters     int64   `json:"polly_characters"`
+	TranscribeJobs      int64   `json:"transcribe_jobs"`
+	TranscribeSeconds   int64   `json:"transcribe_seconds"`
+	TranslateCost       float64 `json:"translate_cost"`
+	PollyCost           float64 `json:"polly_cost"`
+	TranscribeCost      float64 `json:"transcribe_cost"`
+	TotalCost           float64 `json:"total_cost"`
+}
+
+// 文字起こしされる音声の秒数を、読み上げる文字数から見積もる（ジョブごとに最短の課金秒数を適用する）
+func estimateSpeechSeconds(text string) int64 {
+	seconds := int64(utf8.RuneCountInString(text)+speechCharactersPerSecond-1) / speechCharactersPerSecond
+	if seconds < minTranscribeSeconds {
+		return minTranscribeSeconds
+	}
+	return seconds
+}
+
+// 集計した件数と --translate-rate などの単価から料金を見積もる
+func estimateCost(s *runStats, cfg *Config) costEstimate {
+	est := costEstimate{
+		TranslateCharacters: s.translateChars.Load(),
+		PollyCharacters:     s.synthesizeChars.Load(),
+		TranscribeJobs:      s.transcriptionJobs.Load(),
+		TranscribeSeconds:   s.transcribeSeconds.Load(),
+	}
+	est.TranslateCost = float64(est.TranslateCharacters) * cfg.TranslateRate
+	est.PollyCost = float64(est.PollyCharacters) * cfg.PollyRate
+	est.TranscribeCost = float64(est.TranscribeSeconds) * cfg.TranscribeRate
+	est.TotalCost = est.TranslateCost + est.PollyCost + est.TranscribeCost
+	return est
+}
+
+// 見積もった料金を表示する
+func (e costEstimate) print(w io.Writer, dryRun bool) {
+	title := "Estimated cost (approximate, USD):"
+	if dryRun {
+		title = "[DRYRUN] Projected cost (approximate, USD):"
+	}
+	fmt.Fprintln(w, title)
+	fmt.Fprintf(w, "  Translate:  %10d characters  $%.4f\n", e.TranslateCharacters, e.TranslateCost)
+	fmt.Fprintf(w, "  Polly:      %10d characters  $%.4f\n", e.PollyCharacters, e.PollyCost)
+	fmt.Fprintf(w, "  Transcribe: %10d seconds     $%.4f (%d jobs)\n", e.TranscribeSeconds, e.TranscribeCost, e.TranscribeJobs)
+	fmt.Fprintf(w, "  Total:                          $%.4f\n", e.TotalCost)
+}
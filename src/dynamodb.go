@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// --dynamodb-table のキーの属性名
+// パーティションキーは原文の SHA-256、ソートキーは言語の組み合わせと翻訳の設定
+const (
+	memoryHashKey = "text_hash"
+	memorySortKey = "lang_pair"
+)
+
+// 実行やマシンをまたいで訳文を共有する、DynamoDB のテーブルに置いた翻訳メモリー
+// テーブルが使えないときは警告を出して使うのをやめる（--strict ならエラーにする）
+type translationMemory struct {
+	svc     DynamoDBAPI
+	table   string
+	timeout time.Duration
+	strict  bool
+
+	unavailable atomic.Bool
+}
+
+// テーブルがあり、キーの形が合っているかを確かめて翻訳メモリーを作る
+// 使えない場合、--strict でなければ警告を出して nil を返す
+func newTranslationMemory(ctx context.Context, svc DynamoDBAPI, cfg *Config) (*translationMemory, error) {
+	m := &translationMemory{svc: svc, table: cfg.DynamoDBTable, timeout: cfg.Timeout, strict: cfg.Strict}
+	if err := m.checkTable(ctx); err != nil {
+		if m.strict {
+			return nil, err
+		}
+		slog.Warn("DynamoDB translation memory is unavailable; continuing without it", "table", m.table, "error", err)
+		return nil, nil
+	}
+	return m, nil
+}
+
+func (m *translationMemory) checkTable(ctx context.Context) error {
+	callCtx, cancel := callContext(ctx, m.timeout)
+	defer cancel()
+	output, err := m.svc.DescribeTableWithContext(callCtx, &dynamodb.DescribeTableInput{TableName: aws.String(m.table)})
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]string)
+	for _, k := range output.Table.KeySchema {
+		keys[aws.StringValue(k.AttributeName)] = aws.StringValue(k.KeyType)
+	}
+	if len(keys) != 2 || keys[memoryHashKey] != dynamodb.KeyTypeHash || keys[memorySortKey] != dynamodb.KeyTypeRange {
+		return fmt.Errorf("table %s must have partition key %s and sort key %s", m.table, memoryHashKey, memorySortKey)
+	}
+	return nil
+}
+
+// キャッシュのキーに対応するテーブルのキー
+func memoryKey(key cacheKey) map[string]*dynamodb.AttributeValue {
+	sum := sha256.Sum256([]byte(key.Text))
+	pair := key.SourceLang + ":" + key.TargetLang
+	if key.Settings != "" {
+		pair += "#" + key.Settings
+	}
+	return map[string]*dynamodb.AttributeValue{
+		memoryHashKey: {S: aws.String(hex.EncodeToString(sum[:]))},
+		memorySortKey: {S: aws.String(pair)},
+	}
+}
+
+// 記録された訳文を探す
+// 原文の SHA-256 が一致しても原文が違う場合は、見つからなかったものとする
+func (m *translationMemory) get(ctx context.Context, key cacheKey) (translationResult, bool, error) {
+	if m.unavailable.Load() {
+		return translationResult{}, false, nil
+	}
+	callCtx, cancel := callContext(ctx, m.timeout)
+	defer cancel()
+	output, err := m.svc.GetItemWithContext(callCtx, &dynamodb.GetItemInput{
+		TableName: aws.String(m.table),
+		Key:       memoryKey(key),
+	})
+	if err != nil {
+		return translationResult{}, false, m.fail(err)
+	}
+	item := output.Item
+	if item == nil || item["source_text"] == nil || aws.StringValue(item["source_text"].S) != key.Text || item["translation"] == nil {
+		return translationResult{}, false, nil
+	}
+	res := translationResult{Text: aws.StringValue(item["translation"].S)}
+	if item["detected_lang"] != nil {
+		res.DetectedLang = aws.StringValue(item["detected_lang"].S)
+	}
+	return res, true, nil
+}
+
+// 訳文を記録する
+// 既に記録があれば上書きせず、他の実行が先に書いた訳文を残す
+func (m *translationMemory) put(ctx context.Context, key cacheKey, res translationResult) error {
+	if m.unavailable.Load() {
+		return nil
+	}
+	item := memoryKey(key)
+	item["source_text"] = &dynamodb.AttributeValue{S: aws.String(key.Text)}
+	item["translation"] = &dynamodb.AttributeValue{S: aws.String(res.Text)}
+	if res.DetectedLang != "" {
+		item["detected_lang"] = &dynamodb.AttributeValue{S: aws.String(res.DetectedLang)}
+	}
+	item["created_at"] = &dynamodb.AttributeValue{S: aws.String(time.Now().UTC().Format(time.RFC3339))}
+
+	callCtx, cancel := callContext(ctx, m.timeout)
+	defer cancel()
+	_, err := m.svc.PutItemWithContext(callCtx, &dynamodb.PutItemInput{
+		TableName:           aws.String(m.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(" + memoryHashKey + ")"),
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+	if err != nil {
+		return m.fail(err)
+	}
+	return nil
+}
+
+// 呼び出しの失敗を扱う
+// --strict ならそのまま返し、それ以外は最初の失敗で警告を出して以降はテーブルを使わない
+func (m *translationMemory) fail(err error) error {
+	if m.strict {
+		return err
+	}
+	if m.unavailable.CompareAndSwap(false, true) {
+		slog.Warn("DynamoDB translation memory failed; continuing without it", "table", m.table, "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// フラグに対応する環境変数の接頭辞
+const envPrefix = "AWS_CLI_"
+
+// --help の最後に表示する、環境変数での指定の説明
+const envHelp = "\nEvery option can also be set with the environment variable shown in brackets.\n" +
+	"Flags override environment variables, which override --config, which overrides the defaults.\n"
+
+// フラグ名に対応する環境変数名（--target-langs なら AWS_CLI_TARGET_LANGS）
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// 各フラグの説明に対応する環境変数名を書き添える
+func documentEnv(fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		f.Usage += " [$" + envName(f.Name) + "]"
+	})
+}
+
+// 環境変数で指定された値をフラグに設定する
+// 設定ファイルを読んだ後、コマンドラインを解析する前に呼ぶことで、フラグ > 環境変数 > 設定ファイル > 既定値 の順に優先する
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("environment variable %s: %w", envName(f.Name), setErr)
+		}
+	})
+	return err
+}
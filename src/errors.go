@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// どの段階で失敗したかを表すエラー（errors.Is で LineResult.Err などと比べる）
+var (
+	ErrTranslate  = errors.New("translate")
+	ErrSynthesize = errors.New("synthesize")
+	ErrUpload     = errors.New("upload")
+	ErrTranscribe = errors.New("transcribe")
+)
+
+// 一部の入力ファイルや行が失敗したことを表すエラー
+var errPartialFailure = errors.New("partial failure")
+
+// 終了コード
+const (
+	exitOK          = 0
+	exitError       = 1 // フラグや設定の誤り、その他のエラー
+	exitPartial     = 2 // 一部の入力ファイルや行が失敗した
+	exitAWS         = 3 // AWS の呼び出しが失敗した
+	exitInterrupted = 130
+)
+
+// --help の最後に表示する終了コードの説明
+const exitCodeHelp = "\nExit codes:\n" +
+	"  0    success\n" +
+	"  1    invalid options or configuration, or another error\n" +
+	"  2    some input files or lines failed\n" +
+	"  3    an AWS call failed and stopped the run\n" +
+	"  130  interrupted\n"
+
+// 失敗した入力ファイルや行があった実行のエラー（runReport.failure で作る）
+type runFailure struct {
+	Aborted bool // --continue-on-error なしで失敗した、またはどの入力ファイルも成功しなかった
+	err     error
+}
+
+func (e *runFailure) Error() string { return e.err.Error() }
+func (e *runFailure) Unwrap() error { return e.err }
+
+// 実行時のエラーから終了コードを決める
+// 実行を打ち切った原因が AWS の呼び出しの失敗なら、部分的な失敗ではなく AWS のエラーとする
+func exitCodeFor(err error) int {
+	var awsErr awserr.Error
+	var failure *runFailure
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errInterrupted):
+		return exitInterrupted
+	case errors.As(err, &failure) && failure.Aborted && errors.As(err, &awsErr):
+		return exitAWS
+	case errors.Is(err, errPartialFailure):
+		return exitPartial
+	case errors.As(err, &awsErr):
+		return exitAWS
+	}
+	return exitError
+}
+
+// 原因のエラーに失敗した段階を付けたエラー（メッセージは原因のまま）
+type stageError struct {
+	stage error
+	err   error
+}
+
+func (e *stageError) Error() string   { return e.err.Error() }
+func (e *stageError) Unwrap() []error { return []error{e.stage, e.err} }
+
+func withStage(stage, err error) error {
+	return &stageError{stage: stage, err: err}
+}
+
+// エラーメッセージに含める入力行の長さ（文字数）
+const lineSnippetRunes = 40
+
+// 処理を打ち切った行のエラー（errors.As で行番号と入力を取り出せる）
+type LineError struct {
+	InputPath string
+	Line      int
+	Text      string
+	Err       error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d %q: %v", e.Line, snippet(e.Text, lineSnippetRunes), e.Err)
+}
+
+func (e *LineError) Unwrap() error { return e.Err }
+
+// text が n 文字より長ければ切り詰めて "..." を付ける
+func snippet(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n]) + "..."
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestStageAndLineErrors(t *testing.T) {
+	cause := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	lineErr := &LineError{InputPath: "input.txt", Line: 7, Text: strings.Repeat("長", 50), Err: withStage(ErrUpload, cause)}
+	err := fmt.Errorf("input.txt: %w", lineErr)
+
+	if !errors.Is(err, ErrUpload) {
+		t.Error("errors.Is(err, ErrUpload) = false")
+	}
+	if errors.Is(err, ErrTranslate) || errors.Is(err, ErrSynthesize) {
+		t.Error("error matches a stage it did not fail in")
+	}
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) || awsErr.Code() != "ThrottlingException" {
+		t.Errorf("errors.As did not find the AWS error: %v", err)
+	}
+	var gotLine *LineError
+	if !errors.As(err, &gotLine) || gotLine.Line != 7 || gotLine.InputPath != "input.txt" {
+		t.Fatalf("errors.As did not find the LineError: %v", err)
+	}
+
+	// メッセージは原因のままで、行の入力は切り詰める
+	want := fmt.Sprintf("input.txt: line 7 %q: %v", strings.Repeat("長", lineSnippetRunes)+"...", cause)
+	if err.Error() != want {
+		t.Errorf("message %q, want %q", err.Error(), want)
+	}
+	if withStage(ErrTranslate, cause).Error() != cause.Error() {
+		t.Errorf("withStage changed the message")
+	}
+}
+
+func TestExitCodeFor(t *testing.T) {
+	awsCause := fmt.Errorf("input.txt: %w", &LineError{Line: 1, Err: withStage(ErrTranslate, awserr.New("AccessDeniedException", "denied", nil))})
+	otherCause := fmt.Errorf("input.txt: %w", &LineError{Line: 1, Err: errors.New("protected token lost")})
+	failure := func(continueOnError bool, processed int, cause error) error {
+		cfg := &Config{ContinueOnError: continueOnError}
+		report := &runReport{Inputs: 2, Processed: processed, FailedInputs: []string{"input.txt"}, LinesFailed: 1}
+		return report.failure(cfg, []error{cause})
+	}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, exitOK},
+		{"configuration error", errors.New("--bucket is required"), exitError},
+		{"missing input", fmt.Errorf("%w: input.txt", errInputMissing), exitError},
+		{"AWS error", fmt.Errorf("listing voices: %w", awserr.New("AccessDeniedException", "denied", nil)), exitAWS},
+		{"interrupted", fmt.Errorf("input.txt: %w", errInterrupted), exitInterrupted},
+		{"interrupted run", failure(true, 1, fmt.Errorf("input.txt: %w", errInterrupted)), exitInterrupted},
+		{"AWS error stops the run", failure(false, 1, awsCause), exitAWS},
+		{"AWS error in every input", failure(true, 0, awsCause), exitAWS},
+		{"AWS error in some inputs", failure(true, 1, awsCause), exitPartial},
+		{"other error stops the run", failure(false, 1, otherCause), exitPartial},
+		{"other error in some inputs", failure(true, 1, otherCause), exitPartial},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeFor(tt.err); got != tt.want {
+				t.Errorf("exitCodeFor(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// --events-ndjson で書き出すイベントの種類
+const (
+	eventLineStarted   = "line_started"
+	eventTranslated    = "translated"
+	eventUploaded      = "uploaded"
+	eventJobStarted    = "job_started"
+	eventJobCompleted  = "job_completed"
+	eventLineCompleted = "line_completed"
+	eventLineFailed    = "line_failed"
+)
+
+// 1件の進捗イベント（1行の JSON として書き出す）
+type progressEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Stage      string    `json:"stage,omitempty"` // translate・synthesize・upload・transcribe
+	Input      string    `json:"input"`
+	Line       int       `json:"line"`
+	TargetLang string    `json:"target_lang"`
+	AudioKey   string    `json:"audio_key,omitempty"`
+	Reused     bool      `json:"reused,omitempty"` // S3 の既存の音声を使い回したか
+	JobName    string    `json:"job_name,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DryRun     bool      `json:"dry_run,omitempty"`
+}
+
+// 進捗イベントを改行区切りの JSON（NDJSON）で書き出す
+// 複数の goroutine から呼ばれるため、1件ずつ排他して書く
+type eventWriter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	dryRun  bool
+	failed  bool
+}
+
+func newEventWriter(w io.Writer, dryRun bool) *eventWriter {
+	return &eventWriter{encoder: json.NewEncoder(w), dryRun: dryRun}
+}
+
+// イベントを1件書き出す（w が nil なら何もしない）
+// 読み手がいなくなるなどで書けなくなったら、警告を1回だけ出して以降は書かない
+func (w *eventWriter) emit(kind, stage string, res *LineResult) {
+	if w == nil {
+		return
+	}
+	event := progressEvent{
+		Time:       time.Now().UTC(),
+		Event:      kind,
+		Stage:      stage,
+		Input:      res.InputPath,
+		Line:       res.Line,
+		TargetLang: res.TargetLang,
+		AudioKey:   res.AudioKey,
+		Reused:     res.AudioReused,
+		JobName:    res.JobName,
+		DryRun:     w.dryRun,
+	}
+	if res.Err != nil {
+		event.Error = res.Err.Error()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failed {
+		return
+	}
+	if err := w.encoder.Encode(event); err != nil {
+		w.failed = true
+		slog.Warn("writing progress events; no more events will be written", "error", err)
+	}
+}
+
+// エラーが起きた段階の名前（分からなければ空）
+func stageOf(err error) string {
+	for _, stage := range []error{ErrTranslate, ErrSynthesize, ErrUpload, ErrTranscribe} {
+		if errors.Is(err, stage) {
+			return stage.Error()
+		}
+	}
+	return ""
+}
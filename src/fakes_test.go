@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/polly"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/transcribeservice"
+	"github.com/aws/aws-sdk-go/service/translate"
+)
+
+// フラグを解析してテスト用の設定を作る（再試行の待ち時間と状態の確認の間隔は短くする）
+func testConfig(t testing.TB, args ...string) *Config {
+	t.Helper()
+	args = append([]string{"--retry-base-delay", "1ms", "--poll-interval", "1ms"}, args...)
+	cfg, err := parseFlags(args, nil)
+	if err != nil {
+		t.Fatalf("parseFlags(%q): %v", args, err)
+	}
+	return cfg
+}
+
+// スロットリングのエラー（withRetry が再試行する）
+var errThrottled = awserr.New("ThrottlingException", "Rate exceeded", nil)
+
+// 呼び出しごとに errs のエラーを順に返し、尽きたら成功する
+type failures struct {
+	mu    sync.Mutex
+	errs  []error
+	calls int
+}
+
+func (f *failures) next() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (f *failures) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// 原文の前に "[言語] " を付けて訳文とする TranslateAPI（使わない操作は埋め込んだ nil のインターフェイスのまま）
+type fakeTranslate struct {
+	TranslateAPI
+	failures
+	detected string // --source-lang auto のときに返す言語（空なら "ja"）
+
+	mu     sync.Mutex
+	inputs []string // 翻訳を頼まれたテキスト
+}
+
+func (f *fakeTranslate) TextWithContext(ctx aws.Context, input *translate.TextInput, _ ...request.Option) (*translate.TextOutput, error) {
+	if err := f.next(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.inputs = append(f.inputs, aws.StringValue(input.Text))
+	f.mu.Unlock()
+	source := aws.StringValue(input.SourceLanguageCode)
+	if source == autoDetectLanguage {
+		source = f.detected
+		if source == "" {
+			source = "ja"
+		}
+	}
+	return &translate.TextOutput{
+		TranslatedText:     aws.String("[" + aws.StringValue(input.TargetLanguageCode) + "] " + aws.StringValue(input.Text)),
+		SourceLanguageCode: aws.String(source),
+		TargetLanguageCode: input.TargetLanguageCode,
+	}, nil
+}
+
+func (f *fakeTranslate) texts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.inputs)
+}
+
+// size バイトの音声を返す PollyAPI（size が0ならテキストをそのまま音声とする）
+// failAfter を指定すると、音声をその長さまで返したところで streamErr を返す
+type fakePolly struct {
+	PollyAPI
+	failures
+	size      int64
+	failAfter int64
+	streamErr error
+	mu        sync.Mutex
+	inputs    []*polly.SynthesizeSpeechInput
+}
+
+func (f *fakePolly) SynthesizeSpeechWithContext(ctx aws.Context, input *polly.SynthesizeSpeechInput, _ ...request.Option) (*polly.SynthesizeSpeechOutput, error) {
+	if err := f.next(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.inputs = append(f.inputs, input)
+	f.mu.Unlock()
+	var stream io.ReadCloser = io.NopCloser(bytes.NewReader([]byte(aws.StringValue(input.Text))))
+	if f.size > 0 {
+		stream = &zeroStream{size: f.size, failAfter: f.failAfter, err: f.streamErr}
+	}
+	return &polly.SynthesizeSpeechOutput{AudioStream: stream, ContentType: aws.String("audio/mpeg")}, nil
+}
+
+// exists のキーだけがあるとする S3API
+type fakeS3 struct {
+	S3API
+	exists map[string]bool
+}
+
+func (f *fakeS3) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	if f.exists[aws.StringValue(input.Key)] {
+		return &s3.HeadObjectOutput{}, nil
+	}
+	return nil, awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), 404, "")
+}
+
+// ジョブの状態を statuses の順に返す TranscribeAPI（尽きたら最後の状態を返し続ける）
+type fakeTranscribe struct {
+	TranscribeAPI
+	starts        failures
+	statuses      []string
+	failureReason string
+
+	mu      sync.Mutex
+	started []*transcribeservice.StartTranscriptionJobInput
+	polls   int
+}
+
+func (f *fakeTranscribe) StartTranscriptionJobWithContext(ctx aws.Context, input *transcribeservice.StartTranscriptionJobInput, _ ...request.Option) (*transcribeservice.StartTranscriptionJobOutput, error) {
+	if err := f.starts.next(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.started = append(f.started, input)
+	return &transcribeservice.StartTranscriptionJobOutput{}, nil
+}
+
+func (f *fakeTranscribe) GetTranscriptionJobWithContext(ctx aws.Context, input *transcribeservice.GetTranscriptionJobInput, _ ...request.Option) (*transcribeservice.GetTranscriptionJobOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := f.statuses[min(f.polls, len(f.statuses)-1)]
+	f.polls++
+	job := &transcribeservice.TranscriptionJob{
+		TranscriptionJobName:   input.TranscriptionJobName,
+		TranscriptionJobStatus: aws.String(status),
+		LanguageCode:           aws.String("en-US"),
+	}
+	switch status {
+	case transcribeservice.TranscriptionJobStatusCompleted:
+		job.Transcript = &transcribeservice.Transcript{TranscriptFileUri: aws.String("s3://bucket/" + aws.StringValue(input.TranscriptionJobName) + ".json")}
+	case transcribeservice.TranscriptionJobStatusFailed:
+		job.FailureReason = aws.String(f.failureReason)
+	}
+	return &transcribeservice.GetTranscriptionJobOutput{TranscriptionJob: job}, nil
+}
+
+// アップロードされた内容を読み捨て、キーごとの大きさだけを記録する UploaderAPI
+// err を指定すると、body を読み始める前にそのエラーを返す
+type fakeUploader struct {
+	err error
+
+	mu      sync.Mutex
+	sizes   map[string]int64
+	uploads []*s3manager.UploadInput
+}
+
+func (u *fakeUploader) UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, _ ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	if u.err != nil {
+		return nil, u.err
+	}
+	n, err := io.Copy(io.Discard, input.Body)
+	if err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.sizes == nil {
+		u.sizes = make(map[string]int64)
+	}
+	u.sizes[aws.StringValue(input.Key)] = n
+	u.uploads = append(u.uploads, input)
+	return &s3manager.UploadOutput{}, nil
+}
+
+// size バイトの0を返し、failAfter を超えたら err を返すストリーム
+// 大きな音声をメモリに置かずに作るためのもの
+type zeroStream struct {
+	size      int64
+	failAfter int64 // 0 なら失敗しない
+	err       error
+	read      int64
+}
+
+func (s *zeroStream) Read(p []byte) (int, error) {
+	if s.failAfter > 0 && s.read >= s.failAfter {
+		return 0, s.err
+	}
+	if s.read >= s.size {
+		return 0, io.EOF
+	}
+	n := int64(len(p))
+	if remaining := s.size - s.read; n > remaining {
+		n = remaining
+	}
+	clear(p[:n])
+	s.read += n
+	return int(n), nil
+}
+
+func (s *zeroStream) Close() error { return nil }
+
+// 本文を読み捨てるだけの UploaderAPI（ベンチマークで記録を残さないため）
+type discardUploader struct{}
+
+func (discardUploader) UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, _ ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	if _, err := io.Copy(io.Discard, input.Body); err != nil {
+		return nil, err
+	}
+	return &s3manager.UploadOutput{}, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// セッションで使う HTTP クライアントを作る
+// --http-timeout・--proxy・--no-proxy のいずれも指定しなければ nil を返し、SDK の既定のクライアントを使う
+func newHTTPClient(cfg *Config) *http.Client {
+	if cfg.HTTPTimeout == 0 && cfg.proxy == nil && len(cfg.NoProxy) == 0 {
+		return nil
+	}
+	client := &http.Client{Timeout: cfg.HTTPTimeout}
+	if cfg.proxy != nil || len(cfg.NoProxy) > 0 {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = proxyFunc(cfg.proxy, cfg.NoProxy)
+		client.Transport = transport
+	}
+	return client
+}
+
+// リクエストごとに使うプロキシを決める
+// --no-proxy に一致するホストには直接接続し、それ以外は --proxy（なければ HTTPS_PROXY などの環境変数）を使う
+func proxyFunc(proxy *url.URL, noProxy []string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		if proxy != nil {
+			return proxy, nil
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+}
+
+// host が --no-proxy の指定に一致するか
+// "*" は全てのホスト、".example.com" と "example.com" はそのドメインとサブドメイン、CIDR はその範囲の IP アドレスに一致する
+func bypassProxy(host string, noProxy []string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range noProxy {
+		entry = strings.ToLower(entry)
+		if entry == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 標準入力から読み込む場合の --input の指定値
+const stdinPath = "-"
+
+// 入力ファイルがない・翻訳する行がないことを表すエラー
+var (
+	errInputMissing = errors.New("input file not found")
+	errInputEmpty   = errors.New("input file has no lines to translate")
+)
+
+// エラーメッセージで示す、--format ごとの入力の形
+func inputFormatHint(cfg *Config) string {
+	switch cfg.InputFormat {
+	case inputFormatCSV:
+		return fmt.Sprintf("expected a CSV file with the text in column %s", cfg.CSVColumn)
+	case inputFormatJSON:
+		if cfg.JSONField != "" {
+			return fmt.Sprintf("expected a JSON array of objects with a %q string field", cfg.JSONField)
+		}
+		return "expected a JSON array of strings"
+	default:
+		return "expected a UTF-8 text file with one line to translate per line"
+	}
+}
+
+// 入力ファイルの形式
+const (
+	inputFormatText = "txt"
+	inputFormatCSV  = "csv"
+	inputFormatJSON = "json"
+)
+
+// 処理する入力ファイルと、その翻訳結果の出力先
+type inputFile struct {
+	inputPath  string
+	outputPath string
+}
+
+// 処理対象の入力ファイルを列挙する
+// --input-dir の場合は --format の拡張子を持つファイルだけを対象とし、
+// 出力先は --output-dir の下に相対パスを保って配置する
+func collectInputs(cfg *Config) ([]inputFile, error) {
+	if cfg.InputDir == "" {
+		return []inputFile{{inputPath: cfg.InputPath, outputPath: cfg.OutputPath}}, nil
+	}
+
+	ext := "." + cfg.InputFormat
+	var inputs []inputFile
+	err := filepath.WalkDir(cfg.InputDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != cfg.InputDir && !cfg.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.EqualFold(filepath.Ext(path), ext) {
+			return nil
+		}
+		rel, err := filepath.Rel(cfg.InputDir, path)
+		if err != nil {
+			return err
+		}
+		outputPath := filepath.Join(cfg.OutputDir, strings.TrimSuffix(rel, filepath.Ext(rel))+".txt")
+		inputs = append(inputs, inputFile{inputPath: path, outputPath: outputPath})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no %s files found in %s", ext, cfg.InputDir)
+	}
+	return inputs, nil
+}
+
+// --comment-prefix で始まるコメント行か（前後の空白は除いて判定し、prefix が空なら常に false）
+func isCommentLine(line, prefix string) bool {
+	return prefix != "" && strings.HasPrefix(strings.TrimSpace(line), prefix)
+}
+
+// --filter に一致しない行か（--filter がなければ常に false）
+func isFilteredOut(line string, cfg *Config) bool {
+	return cfg.filter != nil && !cfg.filter.MatchString(line)
+}
+
+// 行の選択結果
+type lineSelection struct {
+	selected []bool // 行ごとの処理するかどうか
+	matched  int    // --filter に一致した行数
+	skipped  int    // --filter に一致せず飛ばした行数
+}
+
+// 処理する行を選ぶ
+// 空行・コメント行・--filter に一致しない行を除いた行のうち、
+// 先頭の --offset 行を飛ばして --limit 行までを対象にする（--limit が0なら残り全て）
+func selectLines(lines []string, cfg *Config) lineSelection {
+	sel := lineSelection{selected: make([]bool, len(lines))}
+	n := 0 // 除いた行を数えない通し番号
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" || isCommentLine(line, cfg.CommentPrefix) {
+			continue
+		}
+		if isFilteredOut(line, cfg) {
+			sel.skipped++
+			continue
+		}
+		sel.matched++
+		if n >= cfg.Offset && (cfg.Limit == 0 || n < cfg.Offset+cfg.Limit) {
+			sel.selected[i] = true
+		}
+		n++
+	}
+	return sel
+}
+
+// 入力ファイル（"-" なら標準入力）を開く
+func openInput(path string) (io.ReadCloser, error) {
+	if path == stdinPath {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// 入力ファイルを開いて翻訳対象の行を読み込む
+// ファイルがない場合と、空行やコメント行しかない場合は、期待する形を添えたエラーにする
+func readInputFile(path string, cfg *Config) ([]string, error) {
+	input, err := openInput(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s (%s; set --input or --input-dir)", errInputMissing, path, inputFormatHint(cfg))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	lines, err := getInputText(input, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" && !isCommentLine(line, cfg.CommentPrefix) {
+			return lines, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s (%s)", errInputEmpty, path, inputFormatHint(cfg))
+}
+
+// 翻訳対象を取得（--format に応じて input.txt などから取得）
+func getInputText(r io.Reader, cfg *Config) ([]string, error) {
+	r, err := skipBOM(r)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	switch cfg.InputFormat {
+	case inputFormatCSV:
+		lines, err = getCSVText(r, cfg.CSVColumn, cfg.CSVHeader)
+	case inputFormatJSON:
+		lines, err = getJSONText(r, cfg.JSONField)
+	default:
+		lines, err = getPlainText(r, cfg.MaxLineBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.NormalizeWhitespace {
+		for i, line := range lines {
+			lines[i] = normalizeWhitespace(line)
+		}
+	}
+	return lines, nil
+}
+
+// UTF-8 の BOM
+const utf8BOM = "\ufeff"
+
+// Windows のエディタで保存したファイルの先頭にある BOM を読み飛ばす
+// （残すと最初の行の先頭に入り、翻訳結果や JSON の解析を壊す）
+func skipBOM(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(utf8BOM))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if string(head) == utf8BOM {
+		br.Discard(len(utf8BOM))
+	}
+	return br, nil
+}
+
+// 前後の空白を除き、連続する空白を1つにまとめる
+// 全角スペースだけが続く部分は、日本語の区切りとして全角スペース1つに、それ以外は半角スペース1つにする
+func normalizeWhitespace(line string) string {
+	var b strings.Builder
+	var run []rune
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		if b.Len() > 0 {
+			if strings.Trim(string(run), "\u3000") == "" {
+				b.WriteRune('\u3000')
+			} else {
+				b.WriteByte(' ')
+			}
+		}
+		run = run[:0]
+	}
+	for _, r := range line {
+		if unicode.IsSpace(r) {
+			run = append(run, r)
+			continue
+		}
+		flush()
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// テキストファイルから1行ずつ取得する
+// 1行が maxLineBytes を超える場合はエラーにする（段落を1行に書いたファイルのため既定の64KBより大きくする）
+func getPlainText(r io.Reader, maxLineBytes int) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(maxLineBytes, bufio.MaxScanTokenSize)), maxLineBytes)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("line %d is longer than %d bytes (raise --max-line-bytes): %w", len(lines)+1, maxLineBytes, err)
+		}
+		return nil, err
+	}
+	return lines, nil
+}
+
+// CSVファイルから指定した列の値を取得する
+// column は0始まりの列番号か、header が true の場合はヘッダーの列名
+// 対象の列が空の行は空行と同様に読み飛ばす
+func getCSVText(r io.Reader, column string, header bool) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	index, err := strconv.Atoi(column)
+	if header {
+		names, err := reader.Read()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if index, err = csvColumnIndex(names, column); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("--csv-column %q must be a column index unless --csv-header is set", column)
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("--csv-column must not be negative, got %d", index)
+	}
+
+	var lines []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if index >= len(record) || strings.TrimSpace(record[index]) == "" {
+			continue
+		}
+		lines = append(lines, record[index])
+	}
+}
+
+// ヘッダー行から列番号を求める（列番号での指定も受け付ける）
+func csvColumnIndex(names []string, column string) (int, error) {
+	for i, name := range names {
+		if strings.TrimSpace(name) == column {
+			return i, nil
+		}
+	}
+	if index, err := strconv.Atoi(column); err == nil {
+		return index, nil
+	}
+	return 0, fmt.Errorf("csv column %q not found in header", column)
+}
+
+// JSONファイルから翻訳対象を取得する
+// 文字列の配列、または field で指定した文字列項目を持つオブジェクトの配列を受け付ける
+func getJSONText(r io.Reader, field string) ([]string, error) {
+	var items []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, fmt.Errorf("expected a JSON array of strings or objects: %w", err)
+	}
+
+	lines := make([]string, 0, len(items))
+	for i, item := range items {
+		if field == "" {
+			var text string
+			if err := json.Unmarshal(item, &text); err != nil {
+				return nil, fmt.Errorf("element %d is not a string (use --json-field for an array of objects)", i)
+			}
+			lines = append(lines, text)
+			continue
+		}
+
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(item, &object); err != nil || object == nil {
+			return nil, fmt.Errorf("element %d is not an object (omit --json-field for an array of strings)", i)
+		}
+		value, ok := object[field]
+		if !ok {
+			return nil, fmt.Errorf("element %d has no %q field", i, field)
+		}
+		var text string
+		if err := json.Unmarshal(value, &text); err != nil {
+			return nil, fmt.Errorf("field %q of element %d is not a string", field, i)
+		}
+		lines = append(lines, text)
+	}
+	return lines, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// 選ばれた行の番号
+func selectedLines(sel lineSelection) []int {
+	var picked []int
+	for i, ok := range sel.selected {
+		if ok {
+			picked = append(picked, i)
+		}
+	}
+	return picked
+}
+
+func TestSelectLines(t *testing.T) {
+	lines := []string{"one", "", "# note", "two", "three", "  ", "four", "five"}
+	tests := []struct {
+		name string
+		args []string
+		want []int
+	}{
+		{name: "all", want: []int{0, 2, 3, 4, 6, 7}},
+		{name: "comments skipped", args: []string{"--comment-prefix", "#"}, want: []int{0, 3, 4, 6, 7}},
+		{name: "offset", args: []string{"--comment-prefix", "#", "--offset", "2"}, want: []int{4, 6, 7}},
+		{name: "limit", args: []string{"--comment-prefix", "#", "--limit", "2"}, want: []int{0, 3}},
+		{name: "offset and limit", args: []string{"--comment-prefix", "#", "--offset", "1", "--limit", "3"}, want: []int{3, 4, 6}},
+		{name: "offset past the end", args: []string{"--offset", "10"}},
+		{name: "limit past the end", args: []string{"--comment-prefix", "#", "--offset", "3", "--limit", "10"}, want: []int{6, 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel := selectLines(lines, testConfig(t, tt.args...))
+			if got := selectedLines(sel); !slices.Equal(got, tt.want) {
+				t.Errorf("selected lines %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectLinesFilter(t *testing.T) {
+	lines := []string{"apple pie", "banana", "apple juice", "cherry", "apple tart"}
+	sel := selectLines(lines, testConfig(t, "--filter", "^apple", "--offset", "1", "--limit", "1"))
+	if got := selectedLines(sel); !slices.Equal(got, []int{2}) {
+		t.Errorf("selected lines %v, want [2]", got)
+	}
+	if sel.matched != 3 || sel.skipped != 2 {
+		t.Errorf("matched %d skipped %d, want 3 and 2", sel.matched, sel.skipped)
+	}
+}
+
+func TestIsCommentLine(t *testing.T) {
+	tests := []struct {
+		line, prefix string
+		want         bool
+	}{
+		{"# note", "#", true},
+		{"   # indented note", "#", true},
+		{"text # not a comment", "#", false},
+		{"// note", "//", true},
+		{"# note", "", false},
+		{"", "#", false},
+	}
+	for _, tt := range tests {
+		if got := isCommentLine(tt.line, tt.prefix); got != tt.want {
+			t.Errorf("isCommentLine(%q, %q) = %v, want %v", tt.line, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestReadInputFileCommentsOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(path, []byte("# title\n\n  # note\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readInputFile(path, testConfig(t, "--comment-prefix", "#")); !errors.Is(err, errInputEmpty) {
+		t.Errorf("with --comment-prefix: got %v, want errInputEmpty", err)
+	}
+	// --comment-prefix がなければコメント行も翻訳する行として扱う
+	lines, err := readInputFile(path, testConfig(t))
+	if err != nil {
+		t.Fatalf("without --comment-prefix: %v", err)
+	}
+	if !slices.Equal(lines, []string{"# title", "", "  # note"}) {
+		t.Errorf("got lines %q", lines)
+	}
+}
+
+func TestGetPlainTextLongLine(t *testing.T) {
+	long := strings.Repeat("長い行です。", 10000) // 約180KB
+	input := "first\n" + long + "\nlast\n"
+
+	cfg := testConfig(t)
+	lines, err := getPlainText(strings.NewReader(input), cfg.MaxLineBytes)
+	if err != nil {
+		t.Fatalf("default --max-line-bytes: %v", err)
+	}
+	if len(lines) != 3 || lines[1] != long {
+		t.Errorf("got %d lines, want the long line kept whole", len(lines))
+	}
+
+	cfg = testConfig(t, "--max-line-bytes", "65536")
+	_, err = getPlainText(strings.NewReader(input), cfg.MaxLineBytes)
+	if !errors.Is(err, bufio.ErrTooLong) || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("--max-line-bytes 65536: got %v, want a too-long error for line 2", err)
+	}
+}
+
+func TestReadInputFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tests := []struct {
+		name    string
+		path    string
+		args    []string
+		wantErr error
+		want    []string
+	}{
+		{name: "missing", path: filepath.Join(dir, "missing.txt"), wantErr: errInputMissing},
+		{name: "empty", path: write("empty.txt", ""), wantErr: errInputEmpty},
+		{name: "blank lines", path: write("blank.txt", "\n  \n\t\n"), wantErr: errInputEmpty},
+		{name: "empty JSON array", path: write("empty.json", "[]"), args: []string{"--format", "json"}, wantErr: errInputEmpty},
+		{name: "one line", path: write("one.txt", "\nこんにちは\n"), want: []string{"", "こんにちは"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, tt.args...)
+			lines, err := readInputFile(tt.path, cfg)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.path) || !strings.Contains(err.Error(), inputFormatHint(cfg)) {
+					t.Errorf("message %q does not name the file and the expected format", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readInputFile: %v", err)
+			}
+			if !slices.Equal(lines, tt.want) {
+				t.Errorf("got lines %q, want %q", lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeWhitespace(t *testing.T) {
+	tests := []struct {
+		name, line, want string
+	}{
+		{"unchanged", "Hello world", "Hello world"},
+		{"trim", "  \tHello world \t ", "Hello world"},
+		{"tabs and spaces", "Hello\t\t world\tagain", "Hello world again"},
+		{"ideographic spaces", "　東京　　大阪　", "東京　大阪"},
+		{"mixed run", "東京　 \t大阪", "東京 大阪"},
+		{"only whitespace", " \t　 ", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeWhitespace(tt.line); got != tt.want {
+				t.Errorf("normalizeWhitespace(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+
+	// --normalize-whitespace を付けた場合だけ入力の行に適用する
+	input := "  a\t b \n"
+	for _, args := range [][]string{nil, {"--normalize-whitespace"}} {
+		lines, err := getInputText(strings.NewReader(input), testConfig(t, args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "  a\t b "
+		if args != nil {
+			want = "a b"
+		}
+		if len(lines) != 1 || lines[0] != want {
+			t.Errorf("getInputText with %q = %q, want [%q]", args, lines, want)
+		}
+	}
+}
+
+func TestSkipBOM(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{"with BOM", utf8BOM + "こんにちは\n", "こんにちは\n"},
+		{"without BOM", "こんにちは\n", "こんにちは\n"},
+		{"only BOM", utf8BOM, ""},
+		{"empty", "", ""},
+		{"shorter than a BOM", "a", "a"},
+		{"BOM later in the text", "a" + utf8BOM, "a" + utf8BOM},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := skipBOM(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("skipBOM: %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// CSV や JSON の入力でも最初の値に BOM が残らない
+	for _, tt := range []struct {
+		args  []string
+		input string
+	}{
+		{nil, utf8BOM + "first\nsecond\n"},
+		{[]string{"--format", "csv"}, utf8BOM + "first\nsecond\n"},
+		{[]string{"--format", "json"}, utf8BOM + `["first", "second"]`},
+	} {
+		lines, err := getInputText(strings.NewReader(tt.input), testConfig(t, tt.args...))
+		if err != nil {
+			t.Fatalf("getInputText with %q: %v", tt.args, err)
+		}
+		if !slices.Equal(lines, []string{"first", "second"}) {
+			t.Errorf("getInputText with %q = %q", tt.args, lines)
+		}
+	}
+
+	// BOM 付きのファイルと標準入力の最初の行が、BOM を含まずに翻訳される
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "input.txt")
+	outputPath := filepath.Join(dir, "translated_text.txt")
+	if err := os.WriteFile(inputPath, []byte(utf8BOM+"こんにちは\nさようなら\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	translateSvc := &fakeTranslate{}
+	p := testPipeline(testConfig(t), &Clients{Translate: translateSvc}, modeTranslate)
+	if err := p.processFile(context.Background(), inputFile{inputPath: inputPath, outputPath: outputPath}).failure(); err != nil {
+		t.Fatalf("processFile: %v", err)
+	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := p.Run(context.Background(), strings.NewReader(utf8BOM+"おはよう\n"), &out); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	for _, got := range []string{string(data), out.String()} {
+		if strings.Contains(got, utf8BOM) || !strings.HasPrefix(got, "[en] ") {
+			t.Errorf("translated output %q starts with a BOM", got)
+		}
+	}
+	for _, input := range translateSvc.texts() {
+		if strings.Contains(input, utf8BOM) {
+			t.Errorf("sent %q to Translate with the BOM", input)
+		}
+	}
+}
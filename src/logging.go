@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// --log-format の値
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// --log-level の値を slog のレベルに変換する
+func parseLogLevel(level string) (slog.Level, error) {
+	switch level {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("--log-level must be one of debug, info, warn or error, got %q", level)
+}
+
+// ログの出力先を作る（標準出力は実行結果の表示に使うため、ログは w に書く）
+func newLogger(w io.Writer, cfg *Config) *slog.Logger {
+	level, _ := parseLogLevel(cfg.LogLevel)
+	return newLoggerAt(w, cfg, level)
+}
+
+// --log-format の形式で level 以上のログを w に書くロガーを作る
+func newLoggerAt(w io.Writer, cfg *Config, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.LogFormat == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// --aws-debug で SDK のリクエストのログを w に書くロガーを作る
+// 明示的に頼まれたログのため、--log-level が warn や error でも省かない
+func newAWSDebugLogger(w io.Writer, cfg *Config) aws.Logger {
+	logger := newLoggerAt(w, cfg, slog.LevelDebug)
+	return aws.LoggerFunc(func(args ...interface{}) {
+		logger.Debug("aws sdk", "message", strings.TrimSpace(fmt.Sprint(args...)))
+	})
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAWSDebugLoggerIgnoresLogLevel(t *testing.T) {
+	for _, format := range []string{logFormatText, logFormatJSON} {
+		cfg := testConfig(t, "--aws-debug", "--log-level", "error", "--log-format", format)
+		var buf bytes.Buffer
+		newAWSDebugLogger(&buf, cfg).Log("DEBUG: Request s3/PutObject Details:", "\n")
+		if !strings.Contains(buf.String(), "aws sdk") || !strings.Contains(buf.String(), "s3/PutObject") {
+			t.Errorf("--log-format %s --log-level error: SDK log %q was dropped", format, buf.String())
+		}
+
+		// 通常のログは --log-level に従う
+		buf.Reset()
+		newLogger(&buf, cfg).Info("processing")
+		if buf.Len() != 0 {
+			t.Errorf("--log-level error wrote an info log: %q", buf.String())
+		}
+	}
+}
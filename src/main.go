@@ -1,194 +1,335 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"sort"
 	"strings"
 	"time"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/polly"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/transcribeservice"
-	"github.com/aws/aws-sdk-go/service/translate"
 )
 
 func main() {
-	// AWS セッション作成
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String("ap-northeast-1"),
-	}))
+	args := os.Args[1:]
+	// 先頭の引数がサブコマンド名なら、そのサブコマンドだけを実行する
+	cmd := pipelineCommand()
+	if len(args) > 0 {
+		if c, ok := findCommand(args[0]); ok {
+			cmd, args = c, args[1:]
+		}
+	}
+	os.Exit(runCommand(cmd, args))
+}
 
-	// S3バケットの指定
-	bucketName := "report.3q-aws-s24745201.com"
+// サブコマンドなしで実行する、翻訳・音声合成・文字起こしのパイプライン
+func pipelineCommand() command {
+	return command{
+		run: func(ctx context.Context, clients *Clients, cfg *Config) error {
+			return runPipeline(ctx, clients, cfg, modeFull)
+		},
+	}
+}
 
-	// 入力テキストの読み込み
-	textLines, err := getInputText("./input.txt")
+// 全ての入力に対して、mode で指定した段階を行う
+// 失敗した入力ファイルや行があればエラーを返す
+// --sns-topic-arn の場合は、成功・失敗のどちらでも最後に実行結果を通知する
+func runPipeline(ctx context.Context, clients *Clients, cfg *Config, mode pipelineMode) error {
+	report := &runReport{StartedAt: time.Now()}
+	err := runInputs(ctx, clients, cfg, mode, report)
+	if cfg.SNSTopicARN != "" {
+		notifyRun(ctx, clients.SNS, cfg, report, err)
+	}
+	return err
+}
+
+// runPipeline の本体（report に実行結果を記録していく）
+func runInputs(ctx context.Context, clients *Clients, cfg *Config, mode pipelineMode, report *runReport) error {
+	p, err := newPipeline(ctx, clients, cfg, mode)
 	if err != nil {
-		fmt.Println("Error reading input file:", err)
-		return
+		return err
 	}
 
-	// 翻訳結果を保存するファイル
-	outputFileName := "translated_text.txt"
-	outputFile, err := os.Create(outputFileName)
+	// 処理する入力ファイルの列挙（--input-dir の場合はディレクトリ内の全ファイル）
+	inputs, err := collectInputs(cfg)
 	if err != nil {
-		fmt.Println("Error creating output file:", err)
-		return
+		return fmt.Errorf("listing input files: %w", err)
 	}
+	report.Inputs = len(inputs)
 
-	defer func() {
-		outputFile.Close()
-		// S3アップロード後にテキストファイルを削除
-		err = os.Remove(outputFileName)
-		if err != nil {
-			fmt.Println("Error deleting local text file:", err)
-			return
+	// --manifest の場合は各行の結果を集めて最後に書き出す
+	startedAt := report.StartedAt
+	var manifest *manifestBuilder
+	if cfg.ManifestPath != "" {
+		manifest = newManifestBuilder(cfg, startedAt)
+	}
+
+	// 各行の結果をログに出し、--manifest の場合は記録する
+	p.report = func(res LineResult) {
+		logLineResult(cfg, res)
+		if manifest != nil {
+			manifest.add(res)
 		}
-		fmt.Println("Deleted local text file:", outputFileName)
-	}()
+	}
+	if manifest != nil {
+		p.reportMerged = manifest.addMerged
+	}
+	if cfg.EventsNDJSON {
+		p.events = newEventWriter(os.Stdout, cfg.DryRun)
+	}
 
-	writer := bufio.NewWriter(outputFile)
+	// --checkpoint の場合は前回の実行で完了した行を省き、完了した行を記録していく
+	// （ドライランでは何も完了しないため使わない）
+	if cfg.Checkpoint != "" && !cfg.DryRun {
+		cp, err := openCheckpoint(cfg.Checkpoint)
+		if err != nil {
+			return fmt.Errorf("opening checkpoint: %w", err)
+		}
+		p.checkpoint = cp
+		defer func() {
+			if err := cp.close(); err != nil {
+				slog.Error("closing checkpoint", "path", cfg.Checkpoint, "error", err)
+			}
+		}()
+	}
 
-	for _, txt := range textLines {
-		if strings.TrimSpace(txt) != "" {
-			// テキストを翻訳
-			translatedText, err := translateText(sess, txt)
-			if err != nil {
-				fmt.Println("Error translating text:", err)
-				return
+	var processed, failed []string
+	var causes []error
+	for _, input := range inputs {
+		if stopping(ctx) {
+			break
+		}
+		result := p.processFile(ctx, input)
+		for _, lang := range result.Languages {
+			if lang.Err != nil {
+				slog.Error("processing target language", "input", input.inputPath, "target_lang", lang.TargetLang, "error", lang.Err)
 			}
-			fmt.Println("Translated text:", translatedText)
-			writer.WriteString(translatedText + "\n")
-
-			// 翻訳結果を音声ファイルに変換し、S3にアップロード
-			audioFileName, err := synthesizeSpeechAndUpload(sess, translatedText, bucketName)
-			if err != nil {
-				fmt.Println("Error synthesizing or uploading audio file:", err)
-				return
+			if lang.OutputKey != "" {
+				if cfg.DryRun {
+					slog.Info("[DRYRUN] would upload translated text", "uri", fmt.Sprintf("s3://%s/%s", cfg.Bucket, lang.OutputKey))
+				} else {
+					slog.Info("uploaded translated text to S3", "key", lang.OutputKey)
+				}
 			}
-
-			// 音声ファイルを文字起こし
-			err = transcribeAudioFile(sess, audioFileName, bucketName)
-			if err != nil {
-				fmt.Println("Error transcribing audio file:", err)
-				return
+			// 翻訳結果のテキストファイルはS3へのアップロード後に不要になるため削除する
+			// （翻訳だけを行う場合はこのファイルが結果なので残す）
+			if mode == modeTranslate {
+				slog.Info("wrote translated text", "path", lang.OutputPath)
+				continue
 			}
+			if err := os.Remove(lang.OutputPath); err == nil {
+				slog.Debug("deleted local text file", "path", lang.OutputPath)
+			}
+		}
+		// --input の1ファイルがない・空の場合は、部分的な失敗ではなく指定の誤りとして終える
+		if cfg.InputDir == "" && (errors.Is(result.Err, errInputMissing) || errors.Is(result.Err, errInputEmpty)) {
+			return result.Err
+		}
+		if err := result.failure(); err != nil {
+			slog.Error("processing input file", "input", input.inputPath, "error", err)
+			failed = append(failed, input.inputPath)
+			causes = append(causes, fmt.Errorf("%s: %w", input.inputPath, err))
+			continue
 		}
+		processed = append(processed, input.inputPath)
 	}
-	writer.Flush()
-}
-
-// 翻訳対象を取得（input.txtから取得）
-func getInputText(filePath string) ([]string, error) {
-	inputFile, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
+	report.Processed, report.FailedInputs = len(processed), failed
+	report.LinesSucceeded, report.LinesFailed = p.stats.succeeded.Load(), len(p.failures)
+	// 実行結果のまとめは標準出力に出す（--events-ndjson の場合は標準出力をイベントに使うため標準エラー出力に出す）
+	var w io.Writer = os.Stdout
+	if p.events != nil {
+		w = os.Stderr
+	}
+	if cfg.InputDir != "" {
+		fmt.Fprintf(w, "Processed %d of %d files in %s:\n", len(processed), len(inputs), cfg.InputDir)
+		for _, path := range processed {
+			fmt.Fprintln(w, "  "+path)
+		}
+		if len(failed) > 0 {
+			fmt.Fprintf(w, "Failed %d files:\n", len(failed))
+			for _, path := range failed {
+				fmt.Fprintln(w, "  "+path)
+			}
+		}
 	}
-	defer inputFile.Close()
 
-	var lines []string
-	scanner := bufio.NewScanner(inputFile)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	if cfg.Filter != "" {
+		fmt.Fprintf(w, "Filter %q: %d lines matched, %d skipped\n", cfg.Filter, p.stats.filterMatched.Load(), p.stats.filterSkipped.Load())
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	if cfg.DryRun {
+		p.stats.printDryRunSummary(w)
+	} else {
+		fmt.Fprintf(w, "Translation cache: %d hits, %d misses\n", p.cache.hits.Load(), p.cache.misses.Load())
+		if p.cache.remote != nil {
+			fmt.Fprintf(w, "Translation memory (%s): %d hits\n", cfg.DynamoDBTable, p.cache.remoteHits.Load())
+		}
 	}
-	return lines, nil
-}
-
-// 入力テキストを英語に翻訳する（AWS SDK for Goを使用）
-func translateText(sess *session.Session, text string) (string, error) {
-	translateSvc := translate.New(sess)
-	translateInput := &translate.TextInput{
-		Text:               aws.String(text),
-		SourceLanguageCode: aws.String("ja"),
-		TargetLanguageCode: aws.String("en"),
+	if avg, ok := p.stats.similarity.average(); ok {
+		fmt.Fprintf(w, "Transcript similarity: %.3f average over %d lines\n", avg, p.stats.similarity.lines.Load())
 	}
-	translateResult, err := translateSvc.Text(translateInput)
-	if err != nil {
-		return "", err
+	if avg, ok := p.stats.backSimilarity.average(); ok {
+		fmt.Fprintf(w, "Back-translation similarity: %.3f average over %d lines\n", avg, p.stats.backSimilarity.lines.Load())
 	}
-	return *translateResult.TranslatedText, nil
-}
-
-// 翻訳結果をもとに合成音声による音声ファイルを作成し、S3にアップロードする
-func synthesizeSpeechAndUpload(sess *session.Session, text, bucketName string) (string, error) {
-	pollySvc := polly.New(sess)
-
-	// 合成音声の作成
-	speechInput := &polly.SynthesizeSpeechInput{
-		Text:         aws.String(text),
-		OutputFormat: aws.String("mp3"),
-		VoiceId:      aws.String("Joanna"),
+	cost := estimateCost(&p.stats, cfg)
+	cost.print(w, cfg.DryRun)
+	metrics := collectMetrics(&p.stats, time.Since(startedAt))
+	if !cfg.DryRun {
+		metrics.print(w)
 	}
-	speechOutput, err := pollySvc.SynthesizeSpeech(speechInput)
-	if err != nil {
-		return "", err
+	if cfg.TranslationCache != "" && !cfg.DryRun {
+		if err := p.cache.save(cfg.TranslationCache); err != nil {
+			slog.Error("saving translation cache", "path", cfg.TranslationCache, "error", err)
+		}
 	}
 
-	// 音声ファイルに保存
-	audioFileName := "audioFile-" + time.Now().Format("20060102150405") + "-output.mp3"
-	audioFile, err := os.Create(audioFileName)
-	if err != nil {
-		return "", err
+	if manifest != nil {
+		if err := manifest.write(cfg.ManifestPath, time.Now(), cost, metrics); err != nil {
+			slog.Error("writing manifest", "path", cfg.ManifestPath, "error", err)
+		} else {
+			slog.Info("wrote manifest", "path", cfg.ManifestPath)
+			// --output-to-s3 の場合はマニフェストもバケットに置く
+			if cfg.OutputToS3 {
+				if key, err := uploadManifest(ctx, clients.Uploader, cfg); err != nil {
+					slog.Error("uploading manifest", "path", cfg.ManifestPath, "error", err)
+				} else {
+					report.ManifestURI = fmt.Sprintf("s3://%s/%s", cfg.Bucket, key)
+					if cfg.DryRun {
+						slog.Info("[DRYRUN] would upload manifest", "uri", report.ManifestURI)
+					} else {
+						slog.Info("uploaded manifest to S3", "key", key)
+					}
+				}
+			}
+		}
 	}
-	defer audioFile.Close()
 
-	_, err = audioFile.ReadFrom(speechOutput.AudioStream)
-	if err != nil {
-		return "", err
+	// 行単位の失敗をまとめて報告し、失敗した行を failures.txt に書き出す
+	if cfg.ContinueOnError {
+		fmt.Fprintf(w, "Lines succeeded: %d, failed: %d\n", p.stats.succeeded.Load(), len(p.failures))
+		if len(p.failures) > 0 {
+			if err := writeFailures(failuresFileName, p.failures); err != nil {
+				slog.Error("writing failures file", "path", failuresFileName, "error", err)
+			} else {
+				slog.Info("wrote failed lines", "path", failuresFileName)
+			}
+		}
 	}
-
-	// 音声ファイルをS3にアップロード
-	s3Svc := s3.New(sess)
-	audioFile.Seek(0, 0) // 読み取り可能にするためにシーク
-	_, err = s3Svc.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(audioFileName),
-		Body:   audioFile,
-	})
-	if err != nil {
-		return "", err
+	if p.checkpoint != nil {
+		fmt.Fprintf(w, "Resumed %d lines from checkpoint %s\n", p.stats.resumed.Load(), cfg.Checkpoint)
 	}
-	fmt.Println("Uploaded audio file to S3:", audioFileName)
-
-	// S3アップロード後に音声ファイルを削除
-	err = os.Remove(audioFileName)
-	if err != nil {
-		fmt.Println("Error deleting local audio file:", err)
-		return "", err
+	if stopping(ctx) {
+		return errInterrupted
 	}
-	fmt.Println("Deleted local audio file:", audioFileName)
+	if len(failed) > 0 || len(p.failures) > 0 {
+		return report.failure(cfg, causes)
+	}
+	return nil
+}
 
-	return audioFileName, nil
+// 1行分の処理結果をログに出す
+func logLineResult(cfg *Config, res LineResult) {
+	logger := slog.With("input", res.InputPath, "line", res.Line, "target_lang", res.TargetLang)
+	if res.Err != nil {
+		logger.Error("processing line", "error", res.Err)
+		return
+	}
+	if res.Resumed {
+		logger.Debug("skipped line completed in checkpoint")
+		return
+	}
+	if res.DetectedLang != "" {
+		logger.Debug("detected source language", "source_lang", res.DetectedLang)
+	}
+	logger.Debug("translated text", "text", res.Translation)
+	if res.AudioKey == "" {
+		return
+	}
+	if cfg.DryRun {
+		logger.Info("[DRYRUN] would synthesize and upload audio", "characters", len([]rune(res.Translation)),
+			"voice", res.Voice, "uri", fmt.Sprintf("s3://%s/%s", cfg.Bucket, res.AudioKey))
+		if res.SpeechMarksFile != "" {
+			logger.Info("[DRYRUN] would write speech marks", "path", res.SpeechMarksFile, "key", res.SpeechMarksKey)
+		}
+		if res.JobName != "" {
+			logger.Info("[DRYRUN] would start transcription job", "job", res.JobName)
+		}
+		return
+	}
+	if res.AudioReused {
+		logger.Info("reused existing audio file in S3", "key", res.AudioKey)
+	} else {
+		logger.Info("uploaded audio file to S3", "key", res.AudioKey)
+	}
+	if res.LocalAudioPath != "" {
+		logger.Info("kept local audio file", "path", res.LocalAudioPath)
+	}
+	if res.AudioURL != "" {
+		logger.Info("presigned audio URL", "url", res.AudioURL)
+	}
+	if res.SpeechMarksFile != "" {
+		logger.Info("wrote speech marks", "path", res.SpeechMarksFile, "key", res.SpeechMarksKey)
+	}
+	if res.JobName == "" {
+		return
+	}
+	logger.Info("transcription job completed", "job", res.JobName, "transcript_uri", res.TranscriptURI, "language", res.TranscribeLang,
+		"confidence", fmt.Sprintf("%.3f", res.Confidence))
+	if cfg.MinConfidence > 0 && res.Confidence < cfg.MinConfidence {
+		logger.Warn("low transcription confidence", "confidence", fmt.Sprintf("%.3f", res.Confidence), "min_confidence", cfg.MinConfidence)
+	}
+	logger.Info("wrote transcript text", "path", res.TranscriptFile,
+		"similarity", fmt.Sprintf("%.3f", res.Similarity))
+	if res.SubtitleFile != "" {
+		logger.Info("wrote subtitles", "path", res.SubtitleFile)
+	}
+	if res.BackTranslated {
+		logger.Info("back-translated transcript", "text", res.BackTranslation, "similarity", fmt.Sprintf("%.3f", res.BackSimilarity))
+	}
 }
 
-// 音声ファイルを文字起こしする（Transcribeを使う）
-func transcribeAudioFile(sess *session.Session, audioFileName, bucketName string) error {
-	transcribeSvc := transcribeservice.New(sess)
+// ドライランで実行されるはずだった件数を表示する
+func (s *runStats) printDryRunSummary(w io.Writer) {
+	fmt.Fprintln(w, "[DRYRUN] Summary (no AWS calls were made):")
+	fmt.Fprintf(w, "  lines x target languages: %d\n", s.lines.Load())
+	fmt.Fprintf(w, "  Translate calls:          %d\n", s.translateCalls.Load())
+	fmt.Fprintf(w, "  Polly synthesis calls:    %d\n", s.synthesizeCalls.Load())
+	fmt.Fprintf(w, "  S3 uploads:               %d\n", s.uploads.Load())
+	fmt.Fprintf(w, "  Transcribe jobs:          %d\n", s.transcriptionJobs.Load())
+}
 
-	audioFileURI := fmt.Sprintf("s3://%s/%s", bucketName, audioFileName)
+// --continue-on-error で失敗した行を書き出すファイル
+const failuresFileName = "failures.txt"
 
-	transcriptionJobName := "transcription-job-" + time.Now().Format("20060102150405")
-	transcribeInput := &transcribeservice.StartTranscriptionJobInput{
-		TranscriptionJobName: aws.String(transcriptionJobName),
-		LanguageCode:         aws.String("en-US"),
-		MediaFormat:          aws.String("mp3"),
-		Media: &transcribeservice.Media{
-			MediaFileUri: aws.String(audioFileURI),
-		},
-		OutputBucketName: aws.String(bucketName),
+// 失敗した行の原文を書き出す（そのまま再実行の入力に使えるよう、同じ行は1回だけ書く）
+func writeFailures(path string, failures []LineResult) error {
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].InputPath != failures[j].InputPath {
+			return failures[i].InputPath < failures[j].InputPath
+		}
+		return failures[i].Line < failures[j].Line
+	})
+	var b strings.Builder
+	seen := make(map[string]bool)
+	for _, f := range failures {
+		key := fmt.Sprintf("%s:%d", f.InputPath, f.Line)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		b.WriteString(f.Text + "\n")
 	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
 
-	_, err := transcribeSvc.StartTranscriptionJob(transcribeInput)
-	if err != nil {
-		return err
+// 同じ秒に作られた音声ファイルやジョブの名前が衝突しないように付けるランダムな接尾辞
+func uniqueSuffix() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
 	}
-
-	fmt.Println("Transcription job started:", transcriptionJobName)
-	return nil
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// --manifest で書き出す実行結果の記録
+type manifest struct {
+	Region         string         `json:"region"`
+	Bucket         string         `json:"bucket"`
+	SourceLang     string         `json:"source_lang"`
+	Voice          string         `json:"voice,omitempty"` // --voice 指定時のみ（未指定なら言語ごとに lines[].voice を参照）
+	Engine         string         `json:"engine"`
+	DryRun         bool           `json:"dry_run"`
+	StartedAt      time.Time      `json:"started_at"`
+	FinishedAt     time.Time      `json:"finished_at"`
+	Cost           costEstimate   `json:"estimated_cost"`
+	Metrics        runMetrics     `json:"metrics"`
+	Similarity     *float64       `json:"average_similarity,omitempty"`      // 文字起こしした行の類似度の平均
+	BackSimilarity *float64       `json:"average_back_similarity,omitempty"` // 訳し戻した行の類似度の平均
+	Lines          []manifestLine `json:"lines"`
+}
+
+// 1行・1翻訳先言語分の記録
+type manifestLine struct {
+	InputPath       string   `json:"input_path"`
+	Line            int      `json:"line"`
+	TargetLang      string   `json:"target_lang"`
+	Text            string   `json:"text"`
+	DetectedLang    string   `json:"detected_lang,omitempty"`
+	Translation     string   `json:"translation,omitempty"`
+	Voice           string   `json:"voice,omitempty"`
+	AudioKey        string   `json:"audio_key,omitempty"`
+	Audio           string   `json:"audio,omitempty"` // "created" または "reused"
+	LocalAudioPath  string   `json:"local_audio_path,omitempty"`
+	AudioURL        string   `json:"audio_url,omitempty"`
+	SpeechMarksFile string   `json:"speech_marks_file,omitempty"`
+	MergedAudioKey  string   `json:"merged_audio_key,omitempty"`
+	MergedOffset    *int64   `json:"merged_offset,omitempty"` // つないだ音声の中のこの行の位置（バイト）
+	MergedBytes     int64    `json:"merged_bytes,omitempty"`
+	SpeechMarksKey  string   `json:"speech_marks_key,omitempty"`
+	JobName         string   `json:"job_name,omitempty"`
+	TranscriptURI   string   `json:"transcript_uri,omitempty"`
+	TranscribeLang  string   `json:"transcribe_lang,omitempty"`
+	TranscriptFile  string   `json:"transcript_file,omitempty"`
+	SubtitleFile    string   `json:"subtitle_file,omitempty"`
+	Confidence      float64  `json:"confidence,omitempty"`
+	Similarity      *float64 `json:"similarity,omitempty"`
+	BackTranslation string   `json:"back_translation,omitempty"`
+	BackSimilarity  *float64 `json:"back_similarity,omitempty"`
+	Resumed         bool     `json:"resumed,omitempty"` // 前回までの実行で完了していた行（--checkpoint）
+	Error           string   `json:"error,omitempty"`
+}
+
+// ワーカーから届く処理結果を集めて manifest を組み立てる
+type manifestBuilder struct {
+	mu       sync.Mutex
+	manifest manifest
+}
+
+func newManifestBuilder(cfg *Config, startedAt time.Time) *manifestBuilder {
+	return &manifestBuilder{manifest: manifest{
+		Region:     cfg.Region,
+		Bucket:     cfg.Bucket,
+		SourceLang: cfg.SourceLang,
+		Voice:      cfg.Voice,
+		Engine:     cfg.Engine,
+		DryRun:     cfg.DryRun,
+		StartedAt:  startedAt,
+		Lines:      []manifestLine{},
+	}}
+}
+
+// 1行分の処理結果を追加する
+func (b *manifestBuilder) add(res LineResult) {
+	line := manifestLine{
+		InputPath:       res.InputPath,
+		Line:            res.Line,
+		TargetLang:      res.TargetLang,
+		Text:            res.Text,
+		DetectedLang:    res.DetectedLang,
+		Translation:     res.Translation,
+		Voice:           res.Voice,
+		AudioKey:        res.AudioKey,
+		LocalAudioPath:  res.LocalAudioPath,
+		AudioURL:        res.AudioURL,
+		SpeechMarksFile: res.SpeechMarksFile,
+		SpeechMarksKey:  res.SpeechMarksKey,
+		JobName:         res.JobName,
+		TranscriptURI:   res.TranscriptURI,
+		TranscribeLang:  res.TranscribeLang,
+		TranscriptFile:  res.TranscriptFile,
+		SubtitleFile:    res.SubtitleFile,
+		Confidence:      res.Confidence,
+		Resumed:         res.Resumed,
+	}
+	// 前回までの実行で完了していた行は、音声を作ったか使い回したかが分からないため記録しない
+	if res.AudioKey != "" && !b.manifest.DryRun && !res.Resumed {
+		line.Audio = "created"
+		if res.AudioReused {
+			line.Audio = "reused"
+		}
+	}
+	if res.TranscriptFile != "" {
+		similarity := res.Similarity
+		line.Similarity = &similarity
+	}
+	if res.BackTranslated {
+		backSimilarity := res.BackSimilarity
+		line.BackTranslation = res.BackTranslation
+		line.BackSimilarity = &backSimilarity
+	}
+	if res.Err != nil {
+		line.Error = res.Err.Error()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.manifest.Lines = append(b.manifest.Lines, line)
+}
+
+// 入力ファイル・行番号・翻訳先言語の順に並べて path に書き出す
+func (b *manifestBuilder) write(path string, finishedAt time.Time, cost costEstimate, metrics runMetrics) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines := b.manifest.Lines
+	b.manifest.Similarity = averageOf(lines, func(line manifestLine) *float64 { return line.Similarity })
+	b.manifest.BackSimilarity = averageOf(lines, func(line manifestLine) *float64 { return line.BackSimilarity })
+	sort.SliceStable(lines, func(i, j int) bool {
+		if lines[i].InputPath != lines[j].InputPath {
+			return lines[i].InputPath < lines[j].InputPath
+		}
+		if lines[i].Line != lines[j].Line {
+			return lines[i].Line < lines[j].Line
+		}
+		return lines[i].TargetLang < lines[j].TargetLang
+	})
+	b.manifest.FinishedAt = finishedAt
+	b.manifest.Cost = cost
+	b.manifest.Metrics = metrics
+
+	data, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// 値のある行だけの平均（値のある行がなければ nil）
+func averageOf(lines []manifestLine, value func(manifestLine) *float64) *float64 {
+	var total float64
+	var scored int
+	for _, line := range lines {
+		if v := value(line); v != nil {
+			total += *v
+			scored++
+		}
+	}
+	if scored == 0 {
+		return nil
+	}
+	avg := total / float64(scored)
+	return &avg
+}
+
+// --merge-audio でつないだ音声の中の位置を、対応する行に記録する
+func (b *manifestBuilder) addMerged(merged mergedAudio) {
+	segments := make(map[int]mergedSegment, len(merged.Segments))
+	for _, s := range merged.Segments {
+		segments[s.Line] = s
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.manifest.Lines {
+		line := &b.manifest.Lines[i]
+		s, ok := segments[line.Line]
+		if !ok || line.InputPath != merged.InputPath || line.TargetLang != merged.TargetLang {
+			continue
+		}
+		offset := s.Offset
+		line.MergedAudioKey = merged.Key
+		line.MergedOffset = &offset
+		line.MergedBytes = s.Bytes
+	}
+}
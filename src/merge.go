@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/polly"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// --merge-audio で1つにつないだ音声
+type mergedAudio struct {
+	InputPath  string
+	TargetLang string
+	Key        string
+	Segments   []mergedSegment
+}
+
+// つないだ音声の中の1行分の位置（バイト単位）
+type mergedSegment struct {
+	Line   int
+	Offset int64
+	Bytes  int64
+}
+
+// 入力ファイル・翻訳先言語ごとのつないだ音声のキー（notes.txt なら notes.en.merged.mp3 など）
+// --input-dir の場合は入力ディレクトリからの相対パスを保つ
+func mergedAudioKeyFor(cfg *Config, inputPath, targetLang string) string {
+	name := "stdin"
+	if inputPath != stdinPath {
+		name = filepath.Base(inputPath)
+		if cfg.InputDir != "" {
+			if rel, err := filepath.Rel(cfg.InputDir, inputPath); err == nil {
+				name = filepath.ToSlash(rel)
+			}
+		}
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+	return cfg.S3Prefix + name + "." + targetLang + ".merged" + audioFormats[cfg.AudioFormat].extension
+}
+
+// 行ごとの音声を行の順につなぎ、行の間に --gap-ms の無音を挟んでアップロードする
+// audioKeys は行ごとの音声のキー（音声のない行は空）
+// mp3 はフレームを、pcm・wav はサンプルをそのままつなぐ
+// ogg_vorbis は複数のストリームを連結したファイルになり、最初のストリームしか再生しないプレーヤーもある
+func (p *Pipeline) mergeAudio(ctx context.Context, inputPath, targetLang string, audioKeys []string) error {
+	cfg := p.cfg
+	merged := mergedAudio{InputPath: inputPath, TargetLang: targetLang, Key: mergedAudioKeyFor(cfg, inputPath, targetLang)}
+	var lines []int
+	for i, key := range audioKeys {
+		if key != "" {
+			lines = append(lines, i)
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	if cfg.DryRun {
+		slog.Info("[DRYRUN] would merge audio files", "input", inputPath, "target_lang", targetLang, "files", len(lines),
+			"uri", fmt.Sprintf("s3://%s/%s", cfg.Bucket, merged.Key))
+		p.stats.uploads.Add(1)
+		return nil
+	}
+
+	// WAV はつないだ後に全体のヘッダーを付け直すため、各行のヘッダーは除く
+	var base int64
+	if cfg.AudioFormat == audioFormatWav {
+		base = wavHeaderSize
+	}
+	var data bytes.Buffer
+	var gap []byte
+	for n, i := range lines {
+		audio, err := downloadObject(ctx, p.clients.S3, cfg, audioKeys[i])
+		if err != nil {
+			return fmt.Errorf("downloading audio for line %d: %w", i+1, err)
+		}
+		if cfg.AudioFormat == audioFormatWav {
+			audio = audio[min(len(audio), wavHeaderSize):]
+		}
+		if n > 0 {
+			if gap == nil {
+				if gap, err = silence(cfg, audio, cfg.GapMS); err != nil {
+					return err
+				}
+			}
+			data.Write(gap)
+		}
+		merged.Segments = append(merged.Segments, mergedSegment{Line: i + 1, Offset: base + int64(data.Len()), Bytes: int64(len(audio))})
+		data.Write(audio)
+	}
+
+	var body io.Reader = &data
+	if cfg.AudioFormat == audioFormatWav {
+		sampleRate, err := strconv.Atoi(cfg.SampleRate)
+		if err != nil {
+			return fmt.Errorf("invalid sample rate %q: %w", cfg.SampleRate, err)
+		}
+		body = io.MultiReader(bytes.NewReader(wavHeader(data.Len(), sampleRate)), &data)
+	}
+	uploadCtx, cancel := callContext(ctx, cfg.Timeout)
+	defer cancel()
+	if _, err := p.clients.Uploader.UploadWithContext(uploadCtx, newUploadInput(cfg, merged.Key, body, audioFormats[cfg.AudioFormat].contentType)); err != nil {
+		return fmt.Errorf("uploading merged audio: %w", err)
+	}
+	p.stats.uploads.Add(1)
+	slog.Info("uploaded merged audio to S3", "input", inputPath, "target_lang", targetLang, "files", len(lines), "key", merged.Key)
+	p.reportMerged(merged)
+	return nil
+}
+
+// S3のオブジェクトの内容を読み込む
+func downloadObject(ctx context.Context, s3Svc S3API, cfg *Config, key string) ([]byte, error) {
+	callCtx, cancel := callContext(ctx, cfg.Timeout)
+	defer cancel()
+	output, err := s3Svc.GetObjectWithContext(callCtx, &s3.GetObjectInput{
+		Bucket: aws.String(cfg.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return io.ReadAll(output.Body)
+}
+
+// ms ミリ秒の無音を --audio-format の形式で作る
+// mp3 は sample の最初のフレームと同じ MPEG のバージョン・サンプルレートで作る
+func silence(cfg *Config, sample []byte, ms int) ([]byte, error) {
+	if ms == 0 {
+		return []byte{}, nil
+	}
+	switch cfg.AudioFormat {
+	case polly.OutputFormatMp3:
+		return mp3Silence(sample, ms)
+	case polly.OutputFormatPcm, audioFormatWav:
+		rate := cfg.SampleRate
+		if rate == "" {
+			rate = "16000"
+		}
+		sampleRate, err := strconv.Atoi(rate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample rate %q: %w", rate, err)
+		}
+		return make([]byte, sampleRate*ms/1000*pcmChannels*pcmBitsPerSample/8), nil
+	}
+	return nil, fmt.Errorf("silence is not supported for --audio-format %s", cfg.AudioFormat)
+}
+
+// MPEG のバージョン（ヘッダーの2ビット）ごとのサンプルレート
+var mp3SampleRates = map[byte][3]int{
+	0: {11025, 12000, 8000},  // MPEG 2.5
+	2: {22050, 24000, 16000}, // MPEG 2
+	3: {44100, 48000, 32000}, // MPEG 1
+}
+
+// 無音のフレームのビットレート
+const mp3SilenceBitrate = 32000
+
+// mp3 の無音のフレームを ms ミリ秒分作る
+// サイド情報と主データが全て0のフレームは、デコードすると無音になる
+func mp3Silence(sample []byte, ms int) ([]byte, error) {
+	var version, rateIndex byte
+	found := false
+	for i := 0; i+2 < len(sample); i++ {
+		if sample[i] != 0xFF || sample[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		version, rateIndex = (sample[i+1]>>3)&0x03, (sample[i+2]>>2)&0x03
+		if _, ok := mp3SampleRates[version]; ok && rateIndex != 3 {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errors.New("no MPEG frame header found in the audio")
+	}
+
+	sampleRate := mp3SampleRates[version][rateIndex]
+	// MPEG 1 は1フレーム1152サンプル、MPEG 2・2.5 は576サンプル
+	samplesPerFrame, bitrateIndex, slot := 576, byte(4), 72
+	if version == 3 {
+		samplesPerFrame, bitrateIndex, slot = 1152, 1, 144
+	}
+	frame := make([]byte, slot*mp3SilenceBitrate/sampleRate)
+	frame[0] = 0xFF
+	frame[1] = 0xE0 | version<<3 | 0x01<<1 | 0x01 // Layer III・CRC なし
+	frame[2] = bitrateIndex<<4 | rateIndex<<2
+	frame[3] = 0xC0 // モノラル
+
+	frames := (ms*sampleRate/1000 + samplesPerFrame - 1) / samplesPerFrame
+	return bytes.Repeat(frame, frames), nil
+}
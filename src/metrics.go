@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// 1つの段階の呼び出し回数と所要時間の合計（ワーカーから同時に更新される）
+type stageTimer struct {
+	calls atomic.Int64
+	nanos atomic.Int64
+}
+
+func (t *stageTimer) add(d time.Duration) {
+	t.calls.Add(1)
+	t.nanos.Add(int64(d))
+}
+
+// 段階ごとの所要時間
+type stageTimers struct {
+	translate       stageTimer // Translate の呼び出し（キャッシュから返した分は含めない）
+	synthesize      stageTimer // Polly での合成とS3へのアップロード
+	transcribeStart stageTimer // 文字起こしジョブの開始
+	transcribeWait  stageTimer // 文字起こしジョブの完了待ち
+}
+
+// --manifest の metrics に書き出す1段階分の集計
+type stageMetric struct {
+	Calls        int64   `json:"calls"`
+	TotalSeconds float64 `json:"total_seconds"`
+	AvgMillis    float64 `json:"avg_ms"`
+}
+
+// 実行全体の所要時間とスループット
+type runMetrics struct {
+	ElapsedSeconds float64                `json:"elapsed_seconds"`
+	Lines          int64                  `json:"lines"`
+	LinesPerSecond float64                `json:"lines_per_second"`
+	Stages         map[string]stageMetric `json:"stages"`
+}
+
+func (t *stageTimer) metric() stageMetric {
+	m := stageMetric{Calls: t.calls.Load(), TotalSeconds: time.Duration(t.nanos.Load()).Seconds()}
+	if m.Calls > 0 {
+		m.AvgMillis = m.TotalSeconds * 1000 / float64(m.Calls)
+	}
+	return m
+}
+
+// 表示する順の段階名
+var stageNames = []string{"translate", "synthesize+upload", "transcribe-start", "transcribe-wait"}
+
+// 集計した時間から metrics を求める
+func collectMetrics(s *runStats, elapsed time.Duration) runMetrics {
+	m := runMetrics{
+		ElapsedSeconds: elapsed.Seconds(),
+		Lines:          s.lines.Load(),
+		Stages: map[string]stageMetric{
+			"translate":         s.timers.translate.metric(),
+			"synthesize+upload": s.timers.synthesize.metric(),
+			"transcribe-start":  s.timers.transcribeStart.metric(),
+			"transcribe-wait":   s.timers.transcribeWait.metric(),
+		},
+	}
+	if elapsed > 0 {
+		m.LinesPerSecond = float64(m.Lines) / elapsed.Seconds()
+	}
+	return m
+}
+
+// 段階ごとの所要時間とスループットを表示する
+func (m runMetrics) print(w io.Writer) {
+	fmt.Fprintln(w, "Stage timings:")
+	for _, name := range stageNames {
+		s := m.Stages[name]
+		if s.Calls == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  %-18s %7.1fs over %d calls (%.0fms avg)\n", name+":", s.TotalSeconds, s.Calls, s.AvgMillis)
+	}
+	fmt.Fprintf(w, "Throughput: %d lines in %.1fs (%.2f lines/s)\n", m.Lines, m.ElapsedSeconds, m.LinesPerSecond)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// --sns-topic-arn で通知する、1回の実行の結果
+type runReport struct {
+	StartedAt      time.Time
+	Inputs         int
+	Processed      int
+	FailedInputs   []string
+	LinesSucceeded int64
+	LinesFailed    int
+	ManifestURI    string // --manifest と --output-to-s3 でアップロードしたマニフェスト
+}
+
+// 失敗した入力ファイルや行があった実行のエラーを作る
+// causes は失敗した入力ファイルごとの原因で、終了コードを決めるために残す
+func (r *runReport) failure(cfg *Config, causes []error) error {
+	partial := fmt.Errorf("%w: %d of %d input files and %d lines failed", errPartialFailure, len(r.FailedInputs), r.Inputs, r.LinesFailed)
+	return &runFailure{
+		Aborted: !cfg.ContinueOnError || r.Processed == 0,
+		err:     errors.Join(append([]error{partial}, causes...)...),
+	}
+}
+
+// SNS のメッセージの件名の上限（文字数）
+const maxSNSSubjectLength = 100
+
+// 実行結果と終了時のエラーから、通知に使う状態の名前を決める
+func runStatus(err error) string {
+	switch {
+	case err == nil:
+		return "succeeded"
+	case errors.Is(err, errInterrupted):
+		return "interrupted"
+	case errors.Is(err, errPartialFailure):
+		return "partially failed"
+	}
+	return "failed"
+}
+
+// 実行結果を --sns-topic-arn に送る
+// 中断した場合も送れるよう、ctx の取り消しは引き継がない
+// 送れなかった場合はログに出すだけで、実行の結果（終了コード）は変えない
+func notifyRun(ctx context.Context, snsSvc SNSAPI, cfg *Config, report *runReport, runErr error) {
+	subject, message := report.message(runErr)
+	if cfg.DryRun {
+		slog.Info("[DRYRUN] would publish run summary", "topic", cfg.SNSTopicARN, "subject", subject)
+		return
+	}
+	callCtx, cancel := callContext(context.WithoutCancel(ctx), cfg.Timeout)
+	defer cancel()
+	_, err := snsSvc.PublishWithContext(callCtx, &sns.PublishInput{
+		TopicArn: aws.String(cfg.SNSTopicARN),
+		Subject:  aws.String(subject),
+		Message:  aws.String(message),
+	})
+	if err != nil {
+		slog.Error("publishing run summary", "topic", cfg.SNSTopicARN, "error", err)
+		return
+	}
+	slog.Info("published run summary", "topic", cfg.SNSTopicARN)
+}
+
+// 通知の件名と本文を作る
+func (r *runReport) message(runErr error) (string, string) {
+	name := filepath.Base(os.Args[0])
+	status := runStatus(runErr)
+	subject := fmt.Sprintf("%s run %s", name, status)
+	if len(subject) > maxSNSSubjectLength {
+		subject = subject[:maxSNSSubjectLength]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s run %s.\n\n", name, status)
+	if host, err := os.Hostname(); err == nil {
+		fmt.Fprintf(&b, "Host: %s\n", host)
+	}
+	fmt.Fprintf(&b, "Started: %s\n", r.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Elapsed: %s\n", time.Since(r.StartedAt).Round(time.Second))
+	fmt.Fprintf(&b, "Input files: %d processed, %d failed, %d total\n", r.Processed, len(r.FailedInputs), r.Inputs)
+	fmt.Fprintf(&b, "Lines: %d succeeded, %d failed\n", r.LinesSucceeded, r.LinesFailed)
+	for _, path := range r.FailedInputs {
+		fmt.Fprintf(&b, "  failed: %s\n", path)
+	}
+	if r.ManifestURI != "" {
+		fmt.Fprintf(&b, "Manifest: %s\n", r.ManifestURI)
+	}
+	if runErr != nil {
+		fmt.Fprintf(&b, "Error: %v\n", runErr)
+	}
+	return subject, b.String()
+}
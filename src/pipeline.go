@@ -0,0 +1,751 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"golang.org/x/time/rate"
+)
+
+// 1行・1翻訳先言語分の処理結果
+type LineResult struct {
+	InputPath       string
+	Line            int // 1始まりの行番号
+	TargetLang      string
+	Text            string // 原文
+	DetectedLang    string // 自動判定した翻訳元言語（--source-lang auto の場合のみ）
+	Translation     string
+	Voice           string
+	AudioKey        string
+	AudioReused     bool // S3の既存の音声を使い回したか
+	LocalAudioPath  string
+	SpeechMarksFile string // --speech-marks で書き出したファイル
+	SpeechMarksKey  string
+	AudioURL        string // --presign の場合の期限付きダウンロードURL
+	JobName         string
+	TranscriptURI   string
+	TranscribeLang  string // 文字起こしした言語（--transcribe-auto-detect の場合は判定結果）
+	TranscriptFile  string
+	SubtitleFile    string
+	Confidence      float64 // 文字起こしの単語の信頼度の平均
+	Similarity      float64 // 翻訳文と文字起こし結果の類似度（0〜1）
+	BackTranslated  bool    // --back-translate で訳し戻したか
+	BackTranslation string
+	BackSimilarity  float64 // 入力行と逆翻訳の類似度（0〜1）
+	Resumed         bool    // --checkpoint で前回までに完了していたため処理を省いたか
+	Err             error
+}
+
+// 1翻訳先言語分の処理結果
+type languageResult struct {
+	TargetLang string
+	OutputPath string
+	OutputKey  string // --output-to-s3 でアップロードしたキー
+	Err        error
+}
+
+// 1入力ファイル分の処理結果
+type fileResult struct {
+	InputPath string
+	Languages []languageResult
+	Err       error // 入力ファイル自体を読めなかった場合のエラー
+}
+
+// 失敗した翻訳先言語があればまとめたエラーを返す
+func (f fileResult) failure() error {
+	if f.Err != nil {
+		return f.Err
+	}
+	var causes []error
+	for _, lang := range f.Languages {
+		if lang.Err != nil {
+			causes = append(causes, lang.Err)
+		}
+	}
+	if len(causes) > 0 {
+		// 原因のエラーも残し、AWS の呼び出しの失敗かどうかを終了コードで区別できるようにする
+		return errors.Join(append([]error{fmt.Errorf("%d of %d target languages failed", len(causes), len(f.Languages))}, causes...)...)
+	}
+	return nil
+}
+
+// パイプラインのどの段階を行うか
+type pipelineMode int
+
+const (
+	modeFull       pipelineMode = iota // 翻訳・音声合成・文字起こしの全て
+	modeTranslate                      // 翻訳だけ（translate サブコマンド）
+	modeSynthesize                     // 翻訳済みのテキストの音声合成だけ（synthesize サブコマンド）
+	modeServe                          // 翻訳と音声合成（serve サブコマンド）
+)
+
+func (m pipelineMode) translates() bool  { return m != modeSynthesize }
+func (m pipelineMode) synthesizes() bool { return m != modeTranslate }
+func (m pipelineMode) transcribes() bool { return m == modeFull }
+
+// 翻訳・音声合成・文字起こしのパイプライン
+// newPipeline で作成し、入力ファイルは processFile、任意の入力は Run で処理する（同時に呼び出してよい）
+type Pipeline struct {
+	clients *Clients
+	cfg     *Config
+	mode    pipelineMode
+	limiter *rate.Limiter
+	cache   *translationCache
+	voices  map[string]string // 翻訳先言語ごとの Polly の音声
+	stats   runStats
+
+	// 行の処理が終わるたびに呼ばれる（表示は呼び出し側で行う）
+	report func(LineResult)
+	// --merge-audio で音声をつないでアップロードするたびに呼ばれる
+	reportMerged func(mergedAudio)
+
+	// --checkpoint の場合に完了した行を記録する（なければ nil）
+	checkpoint *checkpoint
+	// --events-ndjson の場合に進捗のイベントを書き出す（なければ nil）
+	events *eventWriter
+
+	failuresMu sync.Mutex
+	failures   []LineResult
+}
+
+// mode の段階を行うパイプラインを作成する
+// 認証情報・バケット・用語集・カスタム語彙・発音辞書・音声・翻訳メモリーのテーブルを事前に確認し、--translation-cache を読み込む
+func newPipeline(ctx context.Context, clients *Clients, cfg *Config, mode pipelineMode) (*Pipeline, error) {
+	// 文字起こしを行う場合だけ、Transcribe が受け付ける音声の形式かを確かめる
+	if mode.transcribes() {
+		if _, err := mediaFormatFor("audio" + audioFormats[cfg.AudioFormat].extension); err != nil {
+			return nil, fmt.Errorf("--audio-format %s cannot be transcribed: Transcribe does not accept raw %s audio (use the synthesize subcommand or another format)", cfg.AudioFormat, cfg.AudioFormat)
+		}
+	}
+
+	// どのアカウントで実行するかを最初に表示し、別のアカウントのバケットへ書き込む誤りを防ぐ
+	// （ドライランではAWSを呼び出さないため省略する）
+	if !cfg.DryRun {
+		identity, err := callerIdentity(ctx, clients.STS, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("checking AWS credentials (profile %q): %w", cfg.Profile, err)
+		}
+		slog.Info("using AWS identity", "account", aws.StringValue(identity.Account), "arn", aws.StringValue(identity.Arn))
+
+		if mode.synthesizes() {
+			if err := ensureBucket(ctx, clients.S3, cfg); err != nil {
+				return nil, fmt.Errorf("checking S3 bucket: %w", err)
+			}
+		}
+	}
+
+	// 指定したカスタム用語集が存在するか事前に確認する
+	if !cfg.DryRun && mode.translates() {
+		if err := checkTerminologies(ctx, clients.Translate, cfg); err != nil {
+			return nil, fmt.Errorf("checking Translate terminologies: %w", err)
+		}
+	}
+
+	// 翻訳先言語ごとの文字起こしの言語を決め、指定したカスタム語彙が使える状態か事前に確認する
+	if mode.transcribes() {
+		checked := make(map[string]bool)
+		for _, lang := range cfg.TargetLangs {
+			languageCode, err := transcribeLanguageFor(cfg, lang)
+			if err != nil {
+				return nil, err
+			}
+			if cfg.DryRun || checked[languageCode] {
+				continue
+			}
+			checked[languageCode] = true
+			if err := checkVocabulary(ctx, clients.Transcribe, cfg, languageCode); err != nil {
+				return nil, fmt.Errorf("checking Transcribe vocabulary: %w", err)
+			}
+		}
+	}
+
+	// 指定した発音辞書が存在するか事前に確認する
+	if !cfg.DryRun && mode.synthesizes() {
+		if err := checkLexicons(ctx, clients.Polly, cfg); err != nil {
+			return nil, fmt.Errorf("checking Polly lexicons: %w", err)
+		}
+	}
+
+	voices := make(map[string]string)
+	if mode.synthesizes() {
+		var err error
+		if voices, err = selectVoices(ctx, clients, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	// ローカルに音声やスピーチマークを残す場合は保存先のディレクトリを用意する
+	if (cfg.KeepAudio || len(cfg.SpeechMarks) > 0) && !cfg.DryRun && mode.synthesizes() {
+		if err := os.MkdirAll(cfg.AudioDir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating audio directory: %w", err)
+		}
+	}
+
+	// 同じ行の翻訳を使い回すキャッシュ（--translation-cache の場合は前回の実行分も読み込む）
+	cache := newTranslationCache()
+	if cfg.TranslationCache != "" {
+		if err := cache.load(cfg.TranslationCache); err != nil {
+			return nil, fmt.Errorf("loading translation cache: %w", err)
+		}
+	}
+	// --dynamodb-table の場合は実行をまたいで共有する翻訳メモリーも使う（ドライランでは翻訳しないため使わない）
+	if cfg.DynamoDBTable != "" && mode.translates() && !cfg.DryRun {
+		memory, err := newTranslationMemory(ctx, clients.DynamoDB, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("checking DynamoDB table: %w", err)
+		}
+		cache.remote = memory
+	}
+
+	// Translate のリクエスト数を全ワーカーで共有して制限する
+	// （Polly と Transcribe にはそれぞれ別のクォータがあり、ここでは制限しない）
+	return &Pipeline{
+		clients:      clients,
+		cfg:          cfg,
+		mode:         mode,
+		limiter:      newTranslateLimiter(cfg.TranslateRPS),
+		cache:        cache,
+		voices:       voices,
+		report:       func(LineResult) {},
+		reportMerged: func(mergedAudio) {},
+	}, nil
+}
+
+// 失敗した行を記録する
+func (p *Pipeline) recordFailure(res LineResult) {
+	p.failuresMu.Lock()
+	defer p.failuresMu.Unlock()
+	p.failures = append(p.failures, res)
+}
+
+// 実行中に集計する件数（ワーカーから同時に更新される）
+type runStats struct {
+	lines             atomic.Int64
+	translateCalls    atomic.Int64
+	synthesizeCalls   atomic.Int64
+	uploads           atomic.Int64
+	transcriptionJobs atomic.Int64
+	succeeded         atomic.Int64
+	filterMatched     atomic.Int64 // --filter に一致した行（翻訳先言語ごとには数えない）
+	filterSkipped     atomic.Int64
+	resumed           atomic.Int64 // --checkpoint に完了と記録されていて省いた行
+
+	// 段階ごとの所要時間
+	timers stageTimers
+
+	// 料金の見積もりに使う量
+	translateChars    atomic.Int64
+	synthesizeChars   atomic.Int64
+	transcribeSeconds atomic.Int64
+
+	similarity     similarityStat // 翻訳文と文字起こし結果の類似度
+	backSimilarity similarityStat // 入力行と --back-translate の逆翻訳の類似度
+}
+
+// 類似度の合計（ワーカーから同時に更新されるため、百万分率の整数で合計する）
+type similarityStat struct {
+	lines atomic.Int64
+	total atomic.Int64
+}
+
+func (s *similarityStat) add(score float64) {
+	s.lines.Add(1)
+	s.total.Add(int64(math.Round(score * 1e6)))
+}
+
+// 類似度の平均（採点した行がなければ ok は false）
+func (s *similarityStat) average() (avg float64, ok bool) {
+	n := s.lines.Load()
+	if n == 0 {
+		return 0, false
+	}
+	return float64(s.total.Load()) / 1e6 / float64(n), true
+}
+
+// 1つの入力ファイルを全ての翻訳先言語について処理する
+// 失敗した言語があっても残りの言語は続行する
+func (p *Pipeline) processFile(ctx context.Context, input inputFile) fileResult {
+	cfg := p.cfg
+	result := fileResult{InputPath: input.inputPath}
+
+	// 入力テキストの読み込み（"-" の場合は標準入力から読む）
+	textLines, err := readInputFile(input.inputPath, cfg)
+	if err != nil {
+		result.Err = fmt.Errorf("reading input file: %w", err)
+		return result
+	}
+
+	selection := selectLines(textLines, cfg)
+	p.stats.filterMatched.Add(int64(selection.matched))
+	p.stats.filterSkipped.Add(int64(selection.skipped))
+	detectedLangs := make([]string, len(textLines))
+	for _, lang := range cfg.TargetLangs {
+		outputPath := outputPathFor(input.outputPath, lang, len(cfg.TargetLangs) > 1)
+		err := p.processLanguageFile(ctx, input.inputPath, lang, outputPath, textLines, selection.selected, detectedLangs)
+		langResult := languageResult{TargetLang: lang, OutputPath: outputPath, Err: err}
+		// 翻訳結果のファイルも音声と同じバケットに残す（中断した場合は途中までの結果なのでアップロードしない）
+		if err == nil && cfg.OutputToS3 {
+			langResult.OutputKey, langResult.Err = uploadOutputFile(ctx, p.clients.Uploader, cfg, outputPath)
+		}
+		result.Languages = append(result.Languages, langResult)
+	}
+	return result
+}
+
+// Run の処理結果のまとめ
+type Summary struct {
+	Lines     []LineResult // 処理した行の結果（行番号・翻訳先言語の順）
+	Succeeded int
+	Failed    int
+}
+
+// input の各行を全ての翻訳先言語について処理し、翻訳結果を out に書き出す
+// 翻訳先言語が複数ある場合は、言語ごとの翻訳結果を --target-langs の順に続けて書き出す
+// 失敗した行や言語があればエラーを返す（それまでに処理した行の結果は Summary に含める）
+func (p *Pipeline) Run(ctx context.Context, input io.Reader, out io.Writer) (Summary, error) {
+	cfg := p.cfg
+	textLines, err := getInputText(input, cfg)
+	if err != nil {
+		return Summary{}, fmt.Errorf("reading input: %w", err)
+	}
+	selection := selectLines(textLines, cfg)
+	p.stats.filterMatched.Add(int64(selection.matched))
+	p.stats.filterSkipped.Add(int64(selection.skipped))
+
+	var (
+		mu      sync.Mutex
+		summary Summary
+	)
+	report := func(res LineResult) {
+		p.report(res)
+		mu.Lock()
+		defer mu.Unlock()
+		summary.Lines = append(summary.Lines, res)
+		if res.Err != nil {
+			summary.Failed++
+		} else {
+			summary.Succeeded++
+		}
+	}
+
+	var errs []error
+	langOrder := make(map[string]int)
+	detectedLangs := make([]string, len(textLines))
+	for i, lang := range cfg.TargetLangs {
+		langOrder[lang] = i
+		if err := p.processLanguage(ctx, stdinPath, lang, out, textLines, selection.selected, detectedLangs, report); err != nil {
+			errs = append(errs, fmt.Errorf("target language %s: %w", lang, err))
+		}
+	}
+	sort.SliceStable(summary.Lines, func(i, j int) bool {
+		a, b := summary.Lines[i], summary.Lines[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return langOrder[a.TargetLang] < langOrder[b.TargetLang]
+	})
+	if len(errs) == 0 && summary.Failed > 0 {
+		errs = append(errs, fmt.Errorf("%d lines failed", summary.Failed))
+	}
+	return summary, errors.Join(errs...)
+}
+
+// 1つの翻訳先言語について処理し、翻訳結果を outputFileName に書き出す
+func (p *Pipeline) processLanguageFile(ctx context.Context, inputPath, targetLang, outputFileName string, textLines []string, selected []bool, detectedLangs []string) (err error) {
+	// 翻訳結果を保存するファイル
+	if err := os.MkdirAll(filepath.Dir(outputFileName), 0o755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+	outputFile, err := os.Create(outputFileName)
+	if err != nil {
+		return fmt.Errorf("creating output file: %w", err)
+	}
+	// 失敗や panic で抜けた場合も含めて必ず閉じ、書きかけのファイルは削除する
+	// （中断した場合は、終わった行までを書き出したファイルとして残す）
+	finished := false
+	defer func() {
+		if closeErr := outputFile.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("writing output file: %w", closeErr)
+		}
+		if !finished || (err != nil && !errors.Is(err, errInterrupted)) {
+			os.Remove(outputFileName)
+		}
+	}()
+	err = p.processLanguage(ctx, inputPath, targetLang, outputFile, textLines, selected, detectedLangs, p.report)
+	finished = true
+	return err
+}
+
+// 1つの翻訳先言語について、翻訳・音声合成・文字起こしを全行に対して行い、翻訳結果を out に書き出す
+// selected が true の行だけを処理し、各行の結果を report に渡す
+// detectedLangs は翻訳元言語の自動判定結果を行ごとに保持し、言語間で共有する
+func (p *Pipeline) processLanguage(ctx context.Context, inputPath, targetLang string, out io.Writer, textLines []string, selected []bool, detectedLangs []string, report func(LineResult)) error {
+	cfg := p.cfg
+	voice := p.voices[targetLang]
+
+	// 行を並列に処理し、結果は行番号の位置に格納する
+	// 最初に失敗した行のエラーで残りの処理を打ち切る（--continue-on-error なら記録して続行する）
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	translations := make([]string, len(textLines))
+	succeeded := make([]bool, len(textLines))
+	audioKeys := make([]string, len(textLines))
+	// 翻訳・音声合成・文字起こしをそれぞれ --concurrency 個の goroutine で行い、段の間をチャネルでつなぐ
+	// 段の間には --stage-buffer 行まで溜められるため、遅い段があっても前の段は先に進める
+	type stagedLine struct {
+		i   int
+		res LineResult
+	}
+	lineIndexes := make(chan int)
+	var resumed []LineResult // --checkpoint により処理を省いた行（行を渡し終えてから読む）
+	translated := make(chan stagedLine, cfg.StageBuffer)
+	synthesized := make(chan stagedLine, cfg.StageBuffer)
+	results := make(chan stagedLine, cfg.StageBuffer)
+	runStage(cfg.Concurrency, translated, func() {
+		for i := range lineIndexes {
+			res := p.translateLine(ctx, inputPath, i+1, targetLang, voice, textLines[i], &detectedLangs[i])
+			translated <- stagedLine{i, res}
+		}
+	})
+	runStage(cfg.Concurrency, synthesized, func() {
+		for line := range translated {
+			if line.res.Err == nil {
+				p.synthesizeLine(ctx, &line.res)
+			}
+			synthesized <- line
+		}
+	})
+	runStage(cfg.Concurrency, results, func() {
+		for line := range synthesized {
+			if line.res.Err == nil {
+				p.transcribeLine(ctx, &line.res, detectedLangs[line.i])
+			}
+			results <- line
+		}
+	})
+
+	go func() {
+		defer close(lineIndexes)
+		for i := range textLines {
+			// 中断の合図を受けたら新しい行を渡さず、処理中の行だけを終わらせる
+			if stopping(ctx) {
+				break
+			}
+			if !selected[i] {
+				continue
+			}
+			// --checkpoint に完了と記録された行は、記録した訳文を使って処理を省く
+			// （--manifest が前回までの行も含むよう、省いた行も結果として渡す）
+			if p.checkpoint != nil {
+				if translation, ok := p.checkpoint.lookup(inputPath, i+1, targetLang, textLines[i]); ok {
+					translations[i] = translation
+					succeeded[i] = true
+					res := LineResult{InputPath: inputPath, Line: i + 1, TargetLang: targetLang, Text: textLines[i], Translation: translation, Voice: voice, Resumed: true}
+					// 音声のキーは内容から決まるため、記録した訳文から求め直せる
+					if p.mode.synthesizes() {
+						if speechText, err := prepareSpeechText(translation, cfg.TextType); err == nil {
+							res.AudioKey = cfg.S3Prefix + audioFileNameFor(cfg, speechText, voice)
+							audioKeys[i] = res.AudioKey
+						}
+					}
+					resumed = append(resumed, res)
+					p.stats.resumed.Add(1)
+					continue
+				}
+			}
+			lineIndexes <- i
+		}
+	}()
+
+	// 結果は行番号の位置に格納するため、段を終えた順に受け取っても出力の順は変わらない
+	var firstErr error
+	for line := range results {
+		i, res := line.i, line.res
+		if res.Err != nil {
+			p.events.emit(eventLineFailed, stageOf(res.Err), &res)
+		} else {
+			p.events.emit(eventLineCompleted, "", &res)
+		}
+		if res.Err != nil && cfg.ContinueOnError && ctx.Err() == nil {
+			p.recordFailure(res)
+			report(res)
+			continue
+		}
+		if res.Err != nil {
+			if firstErr == nil {
+				firstErr = &LineError{InputPath: res.InputPath, Line: res.Line, Text: res.Text, Err: res.Err}
+				cancel()
+			}
+			continue
+		}
+		translations[i] = res.Translation
+		succeeded[i] = true
+		audioKeys[i] = res.AudioKey
+		p.stats.succeeded.Add(1)
+		if p.checkpoint != nil {
+			if err := p.checkpoint.record(res); err != nil {
+				slog.Warn("writing checkpoint", "error", err)
+			}
+		}
+		report(res)
+	}
+	// results が閉じた時点で行を渡す goroutine は終わっているため、省いた行をここで渡す
+	for _, res := range resumed {
+		report(res)
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	// 中断したときも、終わった行までは出力に書き出す
+	var interrupted error
+	if stopping(ctx) {
+		interrupted = errInterrupted
+	}
+
+	// 元の行順で、入力の1行を出力の1行に対応させて書き出す
+	// 空行・失敗した行・--offset と --limit の範囲外の行は空行のまま残し、原文と翻訳を行ごとに比べられるようにする
+	// コメント行は --preserve-comments ならそのまま、--filter に一致しない行は --filter-passthrough ならそのまま書き出す
+	writer := bufio.NewWriter(out)
+	for i, txt := range textLines {
+		switch {
+		case succeeded[i]:
+			writer.WriteString(translations[i])
+		case cfg.PreserveComments && isCommentLine(txt, cfg.CommentPrefix):
+			writer.WriteString(txt)
+		case cfg.FilterPassthrough && strings.TrimSpace(txt) != "" && !isCommentLine(txt, cfg.CommentPrefix) && isFilteredOut(txt, cfg):
+			writer.WriteString(txt)
+		}
+		writer.WriteString("\n")
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if interrupted != nil {
+		return interrupted
+	}
+	if cfg.MergeAudio && p.mode.synthesizes() {
+		return p.mergeAudio(ctx, inputPath, targetLang, audioKeys)
+	}
+	return nil
+}
+
+// 1行分の翻訳を行う（行の処理の最初の段階）
+// detectedLang にはこの行の翻訳元言語の自動判定結果を保持する
+func (p *Pipeline) translateLine(ctx context.Context, inputPath string, line int, targetLang, voice, txt string, detectedLang *string) LineResult {
+	cfg := p.cfg
+	p.stats.lines.Add(1)
+	res := LineResult{InputPath: inputPath, Line: line, TargetLang: targetLang, Text: txt, Voice: voice}
+	p.events.emit(eventLineStarted, ErrTranslate.Error(), &res)
+
+	// テキストを翻訳（判定済みの元言語が翻訳先と同じなら呼び出しを省略する）
+	res.Translation = txt
+	if p.mode.translates() && (cfg.SourceLang != autoDetectLanguage || *detectedLang != targetLang) {
+		started := time.Now()
+		translated, err := translateText(ctx, p.clients.Translate, cfg, p.limiter, p.cache, txt, cfg.SourceLang, targetLang)
+		if err != nil {
+			res.Err = withStage(ErrTranslate, fmt.Errorf("translating text: %w", err))
+			return res
+		}
+		if !translated.Cached {
+			p.stats.timers.translate.add(time.Since(started))
+			p.stats.translateCalls.Add(1)
+			p.stats.translateChars.Add(int64(utf8.RuneCountInString(txt)))
+		}
+		res.Translation = translated.Text
+		if cfg.SourceLang == autoDetectLanguage && translated.DetectedLang != "" {
+			*detectedLang = translated.DetectedLang
+			res.DetectedLang = translated.DetectedLang
+		}
+		p.events.emit(eventTranslated, ErrTranslate.Error(), &res)
+	}
+	return res
+}
+
+// 翻訳した1行の音声を合成してS3にアップロードする（--speech-marks の場合はスピーチマークも作る）
+func (p *Pipeline) synthesizeLine(ctx context.Context, res *LineResult) {
+	if !p.mode.synthesizes() {
+		return
+	}
+	cfg := p.cfg
+
+	// 翻訳結果を音声ファイルに変換し、S3にアップロード
+	started := time.Now()
+	audio, err := synthesizeSpeechAndUpload(ctx, p.clients.Polly, p.clients.Uploader, p.clients.S3, cfg, res.Translation, res.Voice, audioLabels(cfg, res))
+	if err != nil {
+		// S3 への確認・アップロード以外の失敗は合成の失敗とする
+		if !errors.Is(err, ErrUpload) {
+			err = withStage(ErrSynthesize, err)
+		}
+		res.Err = fmt.Errorf("synthesizing or uploading audio file: %w", err)
+		return
+	}
+	if !audio.Reused {
+		p.stats.timers.synthesize.add(time.Since(started))
+		p.stats.synthesizeCalls.Add(1)
+		p.stats.uploads.Add(1)
+		// ドライランでは訳文の代わりに原文の長さで見積もる
+		p.stats.synthesizeChars.Add(int64(utf8.RuneCountInString(strings.TrimPrefix(res.Translation, dryRunPrefix))))
+	}
+	res.AudioKey = audio.AudioKey
+	res.AudioReused = audio.Reused
+	res.LocalAudioPath = audio.LocalPath
+	p.events.emit(eventUploaded, ErrUpload.Error(), res)
+	if cfg.Presign && !cfg.DryRun {
+		if res.AudioURL, err = presignAudioURL(p.clients.S3, cfg, audio.AudioKey); err != nil {
+			res.Err = fmt.Errorf("presigning audio URL: %w", err)
+			return
+		}
+	}
+
+	// 音声の再生位置に合わせて表示するためのスピーチマーク
+	if len(cfg.SpeechMarks) > 0 {
+		marks, err := synthesizeSpeechMarks(ctx, p.clients.Polly, p.clients.Uploader, cfg, res.Translation, res.Voice, audio.AudioKey)
+		if err != nil {
+			res.Err = withStage(ErrSynthesize, fmt.Errorf("synthesizing speech marks: %w", err))
+			return
+		}
+		p.stats.synthesizeCalls.Add(1)
+		p.stats.synthesizeChars.Add(int64(utf8.RuneCountInString(strings.TrimPrefix(res.Translation, dryRunPrefix))))
+		res.SpeechMarksFile = marks.File
+		res.SpeechMarksKey = marks.Key
+	}
+}
+
+// 合成した1行の音声を文字起こしし、訳文との類似度を求める（--back-translate の場合は訳し戻して採点する）
+func (p *Pipeline) transcribeLine(ctx context.Context, res *LineResult, detectedLang string) {
+	if !p.mode.transcribes() {
+		return
+	}
+	cfg := p.cfg
+
+	// 音声ファイルを文字起こし
+	transcription, err := transcribeAudioFile(ctx, p.clients.Transcribe, cfg, res.AudioKey, res.TargetLang, func(jobName string) {
+		started := *res
+		started.JobName = jobName
+		p.events.emit(eventJobStarted, ErrTranscribe.Error(), &started)
+	})
+	if err != nil {
+		res.Err = withStage(ErrTranscribe, fmt.Errorf("transcribing audio file: %w", err))
+		return
+	}
+	p.stats.transcriptionJobs.Add(1)
+	if !cfg.DryRun {
+		p.stats.timers.transcribeStart.add(transcription.StartDuration)
+		p.stats.timers.transcribeWait.add(transcription.WaitDuration)
+	}
+	p.stats.transcribeSeconds.Add(estimateSpeechSeconds(strings.TrimPrefix(res.Translation, dryRunPrefix)))
+	res.JobName = transcription.JobName
+	res.TranscriptURI = transcription.TranscriptURI
+	res.TranscribeLang = transcription.LanguageCode
+	p.events.emit(eventJobCompleted, ErrTranscribe.Error(), res)
+	if cfg.DryRun {
+		// 逆翻訳は、文字起こし結果が訳文と同じ長さになるとみなして見積もる
+		if cfg.BackTranslate && cfg.SourceLang != res.TargetLang {
+			p.stats.translateCalls.Add(1)
+			p.stats.translateChars.Add(int64(utf8.RuneCountInString(strings.TrimPrefix(res.Translation, dryRunPrefix))))
+		}
+		return
+	}
+
+	// 文字起こし結果を取得してテキストファイルに書き出す
+	transcript, err := downloadTranscript(ctx, p.clients.S3, cfg, transcription.JobName)
+	if err != nil {
+		res.Err = withStage(ErrTranscribe, fmt.Errorf("downloading transcript: %w", err))
+		return
+	}
+	res.TranscriptFile = transcript.TextFile
+	res.SubtitleFile = transcript.SubtitleFile
+	res.Confidence = transcript.Confidence
+
+	// 読み上げたテキストがどれだけ正確に文字起こしされたかを求める
+	spoken, err := spokenText(res.Translation, cfg.TextType)
+	if err != nil {
+		res.Err = withStage(ErrTranscribe, fmt.Errorf("scoring transcript: %w", err))
+		return
+	}
+	res.Similarity = textSimilarity(spoken, transcript.Plain, cfg.SimilarityNormalize)
+	p.stats.similarity.add(res.Similarity)
+
+	if cfg.BackTranslate {
+		if err := p.backTranslate(ctx, res, transcript.Plain, detectedLang); err != nil {
+			res.Err = withStage(ErrTranslate, fmt.Errorf("back-translating transcript: %w", err))
+			return
+		}
+	}
+}
+
+// 文字起こし結果を翻訳元言語に訳し戻し、入力行との類似度を res に記録する
+// 合成・文字起こしの劣化と翻訳の誤りをまとめて検出するためのもの
+func (p *Pipeline) backTranslate(ctx context.Context, res *LineResult, transcript, detectedLang string) error {
+	cfg := p.cfg
+	sourceLang := cfg.SourceLang
+	if sourceLang == autoDetectLanguage {
+		sourceLang = detectedLang
+	}
+	if sourceLang == "" {
+		return errors.New("source language was not detected")
+	}
+
+	res.BackTranslation = transcript
+	if sourceLang != res.TargetLang && strings.TrimSpace(transcript) != "" {
+		started := time.Now()
+		translated, err := translateText(ctx, p.clients.Translate, cfg, p.limiter, p.cache, transcript, res.TargetLang, sourceLang)
+		if err != nil {
+			return err
+		}
+		if !translated.Cached {
+			p.stats.timers.translate.add(time.Since(started))
+			p.stats.translateCalls.Add(1)
+			p.stats.translateChars.Add(int64(utf8.RuneCountInString(transcript)))
+		}
+		res.BackTranslation = translated.Text
+	}
+
+	original, err := spokenText(res.Text, cfg.TextType)
+	if err != nil {
+		return err
+	}
+	res.BackTranslated = true
+	res.BackSimilarity = textSimilarity(original, res.BackTranslation, cfg.SimilarityNormalize)
+	p.stats.backSimilarity.add(res.BackSimilarity)
+	return nil
+}
+
+// 翻訳先言語が複数ある場合は、出力ファイル名に言語コードを挟む（translated_text.en.txt など）
+func outputPathFor(outputPath, lang string, multi bool) string {
+	if !multi {
+		return outputPath
+	}
+	ext := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, ext) + "." + lang + ext
+}
+
+// n 個の goroutine で work を実行し、全て終わったら out を閉じる
+func runStage[T any](n int, out chan<- T, work func()) {
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// newPipeline の AWS への事前確認を省き、偽のクライアントでパイプラインを作る
+func testPipeline(cfg *Config, clients *Clients, mode pipelineMode) *Pipeline {
+	voices := make(map[string]string)
+	for _, lang := range cfg.TargetLangs {
+		voices[lang] = "Joanna"
+	}
+	return &Pipeline{
+		clients:      clients,
+		cfg:          cfg,
+		mode:         mode,
+		limiter:      newTranslateLimiter(cfg.TranslateRPS),
+		cache:        newTranslationCache(),
+		voices:       voices,
+		report:       func(LineResult) {},
+		reportMerged: func(mergedAudio) {},
+	}
+}
+
+func TestPipelineKeepsBlankLines(t *testing.T) {
+	const input = "a\n\nb\n\n\nc\n"
+	want := []string{"[en] a", "", "[en] b", "", "", "[en] c"}
+
+	t.Run("processFile", func(t *testing.T) {
+		dir := t.TempDir()
+		inputPath := filepath.Join(dir, "input.txt")
+		outputPath := filepath.Join(dir, "translated_text.txt")
+		if err := os.WriteFile(inputPath, []byte(input), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := testConfig(t, "--input", inputPath, "--output", outputPath, "--concurrency", "4")
+		p := testPipeline(cfg, &Clients{Translate: &fakeTranslate{}}, modeTranslate)
+		if err := p.processFile(context.Background(), inputFile{inputPath: inputPath, outputPath: outputPath}).failure(); err != nil {
+			t.Fatalf("processFile: %v", err)
+		}
+		data, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertLines(t, string(data), want)
+	})
+
+	t.Run("Run", func(t *testing.T) {
+		cfg := testConfig(t, "--concurrency", "4")
+		p := testPipeline(cfg, &Clients{Translate: &fakeTranslate{}}, modeTranslate)
+		var out bytes.Buffer
+		summary, err := p.Run(context.Background(), strings.NewReader(input), &out)
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		if summary.Succeeded != 3 {
+			t.Errorf("%d lines succeeded, want 3", summary.Succeeded)
+		}
+		assertLines(t, out.String(), want)
+	})
+}
+
+// 出力が want と同じ行数で、行ごとに一致するか確かめる
+func assertLines(t *testing.T, output string, want []string) {
+	t.Helper()
+	if !strings.HasSuffix(output, "\n") {
+		t.Fatalf("output %q does not end with a newline", output)
+	}
+	got := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("output has %d lines, want %d: %q", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i+1, got[i], want[i])
+		}
+	}
+}
+
+func TestProcessFileRemovesFilesOnFailure(t *testing.T) {
+	denied := awserr.New("AccessDeniedException", "denied", nil)
+	tests := []struct {
+		name    string
+		clients *Clients
+		wantErr error
+	}{
+		{
+			// 音声を途中まで書いたところで Polly のストリームが切れる
+			name: "synthesis fails while writing audio",
+			clients: &Clients{
+				Translate: &fakeTranslate{},
+				Polly:     &fakePolly{size: 1 << 20, failAfter: 64 << 10, streamErr: errors.New("stream reset")},
+				Uploader:  &fakeUploader{},
+			},
+			wantErr: ErrSynthesize,
+		},
+		{
+			name: "upload fails",
+			clients: &Clients{
+				Translate: &fakeTranslate{},
+				Polly:     &fakePolly{size: 1 << 20},
+				Uploader:  &fakeUploader{err: denied},
+			},
+			wantErr: ErrUpload,
+		},
+		{
+			// どの行も合成まで進まないよう、全ての行の翻訳を失敗させる
+			name:    "translation fails",
+			clients: &Clients{Translate: &fakeTranslate{failures: failures{errs: []error{denied, denied}}}},
+			wantErr: ErrTranslate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			inputPath := filepath.Join(dir, "input.txt")
+			if err := os.WriteFile(inputPath, []byte("one\ntwo\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			outputDir := filepath.Join(dir, "translated")
+			audioDir := filepath.Join(dir, "audio")
+			if err := os.MkdirAll(audioDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			cfg := testConfig(t, "--bucket", "bucket", "--force", "--keep-audio", "--audio-dir", audioDir, "--concurrency", "1")
+			p := testPipeline(cfg, tt.clients, modeServe)
+
+			outputPath := filepath.Join(outputDir, "input.txt")
+			err := p.processFile(context.Background(), inputFile{inputPath: inputPath, outputPath: outputPath}).failure()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if _, err := os.Stat(outputPath); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("output file %s was left behind (stat: %v)", outputPath, err)
+			}
+			entries, err := os.ReadDir(audioDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				t.Errorf("audio file %s was left behind", entry.Name())
+			}
+		})
+	}
+}
+
+func TestProcessFileReportsCheckpointedLines(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "input.txt")
+	outputPath := filepath.Join(dir, "translated_text.txt")
+	if err := os.WriteFile(inputPath, []byte("one\ntwo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// 前回の実行で1行目だけが完了していたことにする
+	checkpointPath := filepath.Join(dir, "checkpoint.ndjson")
+	previous, err := openCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := previous.record(LineResult{InputPath: inputPath, Line: 1, TargetLang: "en", Text: "one", Translation: "[en] one (previous run)"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := previous.close(); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := openCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.close()
+
+	cfg := testConfig(t, "--input", inputPath, "--output", outputPath, "--manifest", filepath.Join(dir, "manifest.json"))
+	svc := &fakeTranslate{}
+	p := testPipeline(cfg, &Clients{Translate: svc}, modeTranslate)
+	p.checkpoint = cp
+	manifest := newManifestBuilder(cfg, time.Now())
+	p.report = manifest.add
+	if err := p.processFile(context.Background(), inputFile{inputPath: inputPath, outputPath: outputPath}).failure(); err != nil {
+		t.Fatalf("processFile: %v", err)
+	}
+	if n := svc.count(); n != 1 {
+		t.Errorf("Translate called %d times, want 1", n)
+	}
+
+	lines := make(map[int]manifestLine)
+	for _, line := range manifest.manifest.Lines {
+		lines[line.Line] = line
+	}
+	if len(lines) != 2 {
+		t.Fatalf("manifest has lines %v, want lines 1 and 2", manifest.manifest.Lines)
+	}
+	if got := lines[1]; !got.Resumed || got.Translation != "[en] one (previous run)" {
+		t.Errorf("line 1 = %+v, want it resumed with the checkpointed translation", got)
+	}
+	if got := lines[2]; got.Resumed || got.Translation != "[en] two" {
+		t.Errorf("line 2 = %+v, want it translated in this run", got)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// 翻訳中に保護する部分の代わりに入れる印（ZX0ZX, ZX1ZX, ...）
+// Translate は単語として訳せない英数字の並びをそのまま残すため、記号よりも崩れにくい
+const placeholderFormat = "ZX%dZX"
+
+// 訳文中の印を探す（Translate が大文字小文字を変えたり、間に空白を入れたりした場合も見つける）
+var placeholderPattern = regexp.MustCompile(`(?i)zx\s*(\d+)\s*zx`)
+
+// --protect-pattern に一致する部分を印に置き換え、元の文字列を順に返す
+func protectTokens(text string, pattern *regexp.Regexp) (string, []string) {
+	if pattern == nil {
+		return text, nil
+	}
+	var tokens []string
+	protected := pattern.ReplaceAllStringFunc(text, func(token string) string {
+		tokens = append(tokens, token)
+		return fmt.Sprintf(placeholderFormat, len(tokens)-1)
+	})
+	return protected, tokens
+}
+
+// 訳文中の印を元の文字列に戻す
+// 訳文から印が消えていた場合はエラーにする
+func restoreTokens(text string, tokens []string) (string, error) {
+	if len(tokens) == 0 {
+		return text, nil
+	}
+	restored := make([]bool, len(tokens))
+	text = placeholderPattern.ReplaceAllStringFunc(text, func(match string) string {
+		i, err := strconv.Atoi(placeholderPattern.FindStringSubmatch(match)[1])
+		if err != nil || i >= len(tokens) {
+			return match
+		}
+		restored[i] = true
+		return tokens[i]
+	})
+	for i, ok := range restored {
+		if !ok {
+			return "", fmt.Errorf("protected token %q was lost in translation", tokens[i])
+		}
+	}
+	return text, nil
+}
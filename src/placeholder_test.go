@@ -0,0 +1,78 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestProtectTokensRoundTrip(t *testing.T) {
+	pattern := regexp.MustCompile(`https?://\S+|\{\{[^}]*\}\}`)
+	tests := []struct {
+		name       string
+		text       string
+		wantTokens []string
+		// Translate の訳文を真似て、保護した文字列を印に変えたテキストを書き換える
+		translate func(protected string) string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:       "url and variable",
+			text:       "詳細は https://example.com/docs?id=1 を見てください、{{name}}さん",
+			wantTokens: []string{"https://example.com/docs?id=1", "{{name}}"},
+			translate:  func(string) string { return "See ZX0ZX for details, ZX1ZX" },
+			want:       "See https://example.com/docs?id=1 for details, {{name}}",
+		},
+		{
+			name:       "reordered and reformatted",
+			text:       "{{user}}さんが{{count}}件のメッセージを送りました",
+			wantTokens: []string{"{{user}}", "{{count}}"},
+			translate:  func(string) string { return "zx 1 zx messages were sent by Zx0zX" },
+			want:       "{{count}} messages were sent by {{user}}",
+		},
+		{
+			name:       "dropped placeholder",
+			text:       "{{user}}さん、https://example.com を開いてください",
+			wantTokens: []string{"{{user}}", "https://example.com"},
+			translate:  func(string) string { return "Please open ZX1ZX" },
+			wantErr:    true,
+		},
+		{
+			name:      "no tokens",
+			text:      "こんにちは",
+			translate: func(protected string) string { return protected },
+			want:      "こんにちは",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protected, tokens := protectTokens(tt.text, pattern)
+			if strings.Join(tokens, "\n") != strings.Join(tt.wantTokens, "\n") {
+				t.Fatalf("tokens %q, want %q", tokens, tt.wantTokens)
+			}
+			for _, token := range tokens {
+				if strings.Contains(protected, token) {
+					t.Errorf("protected text %q still contains %q", protected, token)
+				}
+			}
+			if restored, err := restoreTokens(protected, tokens); err != nil || restored != tt.text {
+				t.Errorf("untranslated round trip = %q, %v; want %q", restored, err, tt.text)
+			}
+
+			got, err := restoreTokens(tt.translate(protected), tokens)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %q, want an error for the lost token", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("restoreTokens: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// バックオフの待ち時間の上限
+const maxRetryDelay = 30 * time.Second
+
+// スロットリングとして再試行するエラーコード
+var throttlingErrorCodes = map[string]bool{
+	"ThrottlingException":      true,
+	"TooManyRequestsException": true,
+	"Throttling":               true,
+	"ThrottledException":       true,
+	"RequestLimitExceeded":     true,
+}
+
+// AWSのエラーがスロットリングによるものか判定する
+func isThrottlingError(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && throttlingErrorCodes[aerr.Code()]
+}
+
+// スロットリングされた呼び出しを指数バックオフ（ジッター付き）で再試行する
+// スロットリング以外のエラーは再試行せずにそのまま返す
+func withRetry(ctx context.Context, cfg *Config, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if err == nil || !isThrottlingError(err) || attempt >= cfg.MaxRetries {
+			return err
+		}
+		delay := retryDelay(cfg.RetryBaseDelay, attempt)
+		slog.Debug("retrying throttled call", "attempt", attempt+1, "delay", delay, "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt 回目の再試行までの待ち時間（base * 2^attempt の半分以上をランダムに選ぶ）
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	delay := maxRetryDelay
+	if attempt < 30 && base<<attempt < maxRetryDelay {
+		delay = base << attempt
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int64N(int64(delay-half)+1))
+}
+
+// 1回のAWS呼び出しに使うコンテキストを作る（timeout が0なら期限を設けない）
+func callContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// リクエストの本文の上限
+const maxRequestBytes = 1 << 20
+
+// サーバーを止めるときに処理中のリクエストを待つ時間
+const shutdownTimeout = 30 * time.Second
+
+// POST /synthesize のリクエスト
+type synthesizeRequest struct {
+	Text       string `json:"text"`
+	SourceLang string `json:"sourceLang"` // 省略時は --source-lang
+	TargetLang string `json:"targetLang"`
+	Voice      string `json:"voice"` // 省略時は --voice-map などから選ぶ
+}
+
+// POST /synthesize のレスポンス
+type synthesizeResponse struct {
+	Translation  string `json:"translation"`
+	DetectedLang string `json:"detectedLang,omitempty"`
+	Voice        string `json:"voice"`
+	AudioKey     string `json:"audioKey"`
+	AudioURL     string `json:"audioUrl,omitempty"` // ドライランでは空
+}
+
+// 中断の合図を受けるまで addr で HTTP サーバーを動かす
+// 終了時は処理中のリクエストが終わるのを待ってから戻る
+func serve(ctx context.Context, p *Pipeline, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /synthesize", p.handleSynthesize)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", addr)
+		errc <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-stopRequested(ctx):
+	}
+
+	slog.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down server: %w", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// 1件のテキストを翻訳・音声合成し、訳文と音声の期限付きURLを返す
+func (p *Pipeline) handleSynthesize(w http.ResponseWriter, req *http.Request) {
+	var body synthesizeRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if err := validateSynthesizeRequest(&body, p.cfg); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	summary, err := p.forRequest(body).Run(req.Context(), strings.NewReader(body.Text), io.Discard)
+	if err != nil {
+		status := http.StatusBadGateway
+		if req.Context().Err() != nil {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSONError(w, status, err)
+		return
+	}
+	if len(summary.Lines) != 1 {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("expected 1 result, got %d", len(summary.Lines)))
+		return
+	}
+	res := summary.Lines[0]
+	writeJSON(w, http.StatusOK, synthesizeResponse{
+		Translation:  res.Translation,
+		DetectedLang: res.DetectedLang,
+		Voice:        res.Voice,
+		AudioKey:     res.AudioKey,
+		AudioURL:     res.AudioURL,
+	})
+}
+
+// リクエストの内容を検証し、省略された項目をサーバーの設定で埋める
+func validateSynthesizeRequest(body *synthesizeRequest, cfg *Config) error {
+	body.Text = strings.TrimSpace(body.Text)
+	if body.Text == "" {
+		return errors.New("text must not be empty")
+	}
+	if strings.ContainsAny(body.Text, "\r\n") {
+		return errors.New("text must be a single line")
+	}
+	if body.SourceLang == "" {
+		body.SourceLang = cfg.SourceLang
+	}
+	if body.SourceLang != autoDetectLanguage {
+		if err := validateLanguageCode(body.SourceLang); err != nil {
+			return fmt.Errorf("sourceLang: %w", err)
+		}
+	}
+	if err := validateLanguageCode(body.TargetLang); err != nil {
+		return fmt.Errorf("targetLang: %w", err)
+	}
+	if body.Voice == "" {
+		voice, err := voiceForLanguage(cfg, body.TargetLang)
+		if err != nil {
+			return err
+		}
+		body.Voice = voice
+	}
+	return nil
+}
+
+// 1件のリクエストを処理するためのパイプラインを作る
+// クライアント・Translate のレート制限・翻訳キャッシュはサーバー全体で共有する
+func (p *Pipeline) forRequest(body synthesizeRequest) *Pipeline {
+	cfg := *p.cfg
+	cfg.SourceLang = body.SourceLang
+	cfg.TargetLangs = []string{body.TargetLang}
+	cfg.Voice = body.Voice
+	cfg.Presign = true
+	cfg.MergeAudio = false
+	cfg.InputFormat = inputFormatText
+	cfg.CommentPrefix, cfg.filter, cfg.Offset, cfg.Limit = "", nil, 0, 0
+	return &Pipeline{
+		clients:      p.clients,
+		cfg:          &cfg,
+		mode:         p.mode,
+		limiter:      p.limiter,
+		cache:        p.cache,
+		voices:       map[string]string{body.TargetLang: body.Voice},
+		report:       p.report,
+		reportMerged: p.reportMerged,
+	}
+}
+
+// v をJSONでレスポンスに書き出す
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("writing response", "error", err)
+	}
+}
+
+// エラーを {"error": "..."} の形で返す
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
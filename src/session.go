@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// AWS セッションを作成する
+// --profile を指定した場合は ~/.aws/config と ~/.aws/credentials の名前付きプロファイルを使う
+// --assume-role-arn を指定した場合は、その認証情報でロールを引き受けた一時認証情報を全サービスで使う
+func newSession(ctx context.Context, cfg *Config) (*session.Session, error) {
+	awsCfg := aws.Config{
+		Region:     aws.String(cfg.Region),
+		MaxRetries: aws.Int(cfg.SDKMaxRetries),
+	}
+	// セッションから作る全サービスのクライアントで同じ HTTP クライアントを使う
+	if client := newHTTPClient(cfg); client != nil {
+		awsCfg.HTTPClient = client
+	}
+	// --endpoint-url の場合は LocalStack などに向けるため、全サービスの接続先を差し替えて
+	// S3 はバケット名をホスト名に含めないパス形式でアクセスする
+	if cfg.EndpointURL != "" {
+		awsCfg.Endpoint = aws.String(cfg.EndpointURL)
+		awsCfg.S3ForcePathStyle = aws.Bool(true)
+	}
+	if cfg.AccessKey != "" {
+		awsCfg.Credentials = credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, "")
+	}
+	// --aws-debug の場合は SDK のリクエストのログを --log-level によらず標準エラー出力に書く
+	if cfg.AWSDebug {
+		level := aws.LogDebugWithSigning | aws.LogDebugWithRequestRetries | aws.LogDebugWithRequestErrors
+		if cfg.AWSDebugBody {
+			level |= aws.LogDebugWithHTTPBody
+		}
+		awsCfg.LogLevel = aws.LogLevel(level)
+		awsCfg.Logger = newAWSDebugLogger(os.Stderr, cfg)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsCfg,
+		Profile:           cfg.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating AWS session: %w", err)
+	}
+	if cfg.AssumeRoleARN == "" {
+		return sess, nil
+	}
+
+	creds := stscreds.NewCredentials(sess, cfg.AssumeRoleARN, func(p *stscreds.AssumeRoleProvider) {
+		if cfg.ExternalID != "" {
+			p.ExternalID = aws.String(cfg.ExternalID)
+		}
+	})
+	// ロールを引き受けられない場合は、S3などの呼び出しの途中ではなくここでエラーにする
+	// （ドライランではAWSを呼び出さないため省略する）
+	if !cfg.DryRun {
+		callCtx, cancel := callContext(ctx, cfg.Timeout)
+		defer cancel()
+		if _, err := creds.GetWithContext(callCtx); err != nil {
+			return nil, fmt.Errorf("assuming role %s: %w", cfg.AssumeRoleARN, err)
+		}
+	}
+	return sess.Copy(&aws.Config{Credentials: creds}), nil
+}
+
+// 実行に使う認証情報のアカウントとIDを確認する
+// 認証情報の誤りを、S3へのアップロードなどを始める前に検出する
+func callerIdentity(ctx context.Context, stsSvc STSAPI, cfg *Config) (*sts.GetCallerIdentityOutput, error) {
+	callCtx, cancel := callContext(ctx, cfg.Timeout)
+	defer cancel()
+	return stsSvc.GetCallerIdentityWithContext(callCtx, &sts.GetCallerIdentityInput{})
+}
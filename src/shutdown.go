@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// 中断の合図を受けて処理を途中で終えたことを表すエラー
+var errInterrupted = errors.New("interrupted")
+
+type stopKey struct{}
+
+// SIGINT・SIGTERM を受けたら、新しい行の処理を止める合図を ctx に送る
+// 処理中の行は grace の間だけ待ち、過ぎたら ctx を取り消す
+// 2回目のシグナルを受けたら待たずに終了する
+// 戻り値の関数でシグナルの監視をやめる
+func withGracefulShutdown(parent context.Context, grace time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	stopCh := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		slog.Warn("interrupted; finishing lines in progress (interrupt again to exit immediately)", "grace", grace)
+		close(stopCh)
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-signals:
+			slog.Error("interrupted again; exiting immediately")
+			os.Exit(exitInterrupted)
+		case <-timer.C:
+			slog.Warn("grace period expired; cancelling lines in progress")
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-signals:
+			slog.Error("interrupted again; exiting immediately")
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+
+	return context.WithValue(ctx, stopKey{}, (<-chan struct{})(stopCh)), func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
+
+// 新しい作業を始めずに終えるよう合図されたときに閉じるチャネル（合図がなければ ctx.Done()）
+func stopRequested(ctx context.Context) <-chan struct{} {
+	if ch, ok := ctx.Value(stopKey{}).(<-chan struct{}); ok {
+		return ch
+	}
+	return ctx.Done()
+}
+
+// 新しい作業を始めずに終えるよう合図されたか
+func stopping(ctx context.Context) bool {
+	select {
+	case <-stopRequested(ctx):
+		return true
+	default:
+		return ctx.Err() != nil
+	}
+}
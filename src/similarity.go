@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/service/polly"
+)
+
+// --similarity-normalize で指定できる正規化
+const (
+	normalizeLowercase   = "lowercase"
+	normalizePunctuation = "punctuation"
+	normalizeNone        = "none"
+)
+
+// 類似度を求める前にテキストを正規化する
+// 句読点・記号は空白に置き換え、連続する空白は1つにまとめる
+func normalizeForSimilarity(text string, opts []string) string {
+	for _, opt := range opts {
+		switch opt {
+		case normalizeLowercase:
+			text = strings.ToLower(text)
+		case normalizePunctuation:
+			text = strings.Map(func(r rune) rune {
+				if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+					return ' '
+				}
+				return r
+			}, text)
+		}
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// 読み上げたテキストと文字起こし結果の類似度を 0〜1 で求める
+// 文字単位の編集距離を長い方の文字数で割ったものを1から引く（分かち書きしない言語でも使える）
+func textSimilarity(spoken, transcribed string, opts []string) float64 {
+	a := []rune(normalizeForSimilarity(spoken, opts))
+	b := []rune(normalizeForSimilarity(transcribed, opts))
+	longest := max(len(a), len(b))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// 2つの文字列の編集距離（挿入・削除・置換をそれぞれ1と数える）
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// Polly が実際に読み上げる文字列を取り出す（SSMLの場合はタグを除く）
+func spokenText(text, textType string) (string, error) {
+	if textType != polly.TextTypeSsml {
+		return text, nil
+	}
+	ssml, err := prepareSpeechText(text, textType)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	decoder := xml.NewDecoder(strings.NewReader(ssml))
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return b.String(), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid SSML: %w", err)
+		}
+		if data, ok := tok.(xml.CharData); ok {
+			b.Write(data)
+			b.WriteString(" ")
+		}
+	}
+}
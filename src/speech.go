@@ -0,0 +1,653 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/polly"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// 翻訳先言語ごとの Polly の既定音声
+var defaultVoices = map[string]string{
+	"ar":    "Zeina",
+	"cy":    "Gwyneth",
+	"da":    "Naja",
+	"de":    "Vicki",
+	"en":    "Joanna",
+	"es":    "Lucia",
+	"es-MX": "Mia",
+	"fr":    "Lea",
+	"fr-CA": "Chantal",
+	"hi":    "Aditi",
+	"is":    "Dora",
+	"it":    "Bianca",
+	"ja":    "Mizuki",
+	"ko":    "Seoyeon",
+	"nl":    "Lotte",
+	"no":    "Liv",
+	"pl":    "Ewa",
+	"pt":    "Camila",
+	"ro":    "Carmen",
+	"ru":    "Tatyana",
+	"sv":    "Astrid",
+	"tr":    "Filiz",
+	"zh":    "Zhiyu",
+}
+
+// Polly の出力形式ごとの拡張子・Content-Type
+type audioFormat struct {
+	extension   string
+	contentType string
+}
+
+var audioFormats = map[string]audioFormat{
+	polly.OutputFormatMp3:       {".mp3", "audio/mpeg"},
+	polly.OutputFormatOggVorbis: {".ogg", "audio/ogg"},
+	polly.OutputFormatPcm:       {".pcm", "audio/pcm"},
+	audioFormatWav:              {".wav", "audio/wav"},
+}
+
+// Polly の出力形式ごとに指定できるサンプルレート（Hz）
+// 省略時の値は mp3・ogg_vorbis では standard が 22050、neural が 24000、pcm は 16000
+// どのエンジンでも同じ値を受け付ける
+var pollySampleRates = map[string][]string{
+	polly.OutputFormatMp3:       {"8000", "16000", "22050", "24000"},
+	polly.OutputFormatOggVorbis: {"8000", "16000", "22050", "24000"},
+	polly.OutputFormatPcm:       {"8000", "16000"},
+}
+
+// --audio-format の形式を作るために Polly に要求する出力形式
+func pollyOutputFormat(audioFormat string) string {
+	if audioFormat == audioFormatWav {
+		return polly.OutputFormatPcm
+	}
+	return audioFormat
+}
+
+// Translate と Polly で言語コードの表し方が違う言語（Polly 側の言語部分）
+var pollyLanguageAliases = map[string]string{
+	"ar": "arb",
+	"no": "nb",
+	"zh": "cmn",
+}
+
+// 翻訳先言語に合う Polly の音声を返す（AWSに問い合わせずに決められる範囲で選ぶ）
+// --voice、--voice-map、既定音声の順に優先する
+func voiceForLanguage(cfg *Config, lang string) (string, error) {
+	if cfg.Voice != "" {
+		return cfg.Voice, nil
+	}
+	if voice, ok := cfg.VoiceMap[lang]; ok {
+		return voice, nil
+	}
+	if voice, ok := defaultVoices[lang]; ok {
+		return voice, nil
+	}
+	// zh-TW のような地域付きコードは言語部分でも探す
+	if base, _, found := strings.Cut(lang, "-"); found {
+		if voice, ok := defaultVoices[base]; ok {
+			return voice, nil
+		}
+	}
+	return "", fmt.Errorf("no Polly voice configured for target language %q (use --voice-map)", lang)
+}
+
+// DescribeVoices の結果のキャッシュ（行ごとに問い合わせないようにする）
+type voiceCatalog struct {
+	voices map[string]*polly.Voice
+}
+
+// リージョンで利用可能な音声を一度だけ取得する
+func loadVoiceCatalog(ctx context.Context, pollySvc PollyAPI, cfg *Config) (*voiceCatalog, error) {
+	catalog := &voiceCatalog{voices: make(map[string]*polly.Voice)}
+	input := &polly.DescribeVoicesInput{}
+	for {
+		var output *polly.DescribeVoicesOutput
+		err := withRetry(ctx, cfg, func() error {
+			callCtx, cancel := callContext(ctx, cfg.Timeout)
+			defer cancel()
+			var err error
+			output, err = pollySvc.DescribeVoicesWithContext(callCtx, input)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range output.Voices {
+			catalog.voices[aws.StringValue(v.Id)] = v
+		}
+		if aws.StringValue(output.NextToken) == "" {
+			return catalog, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// 翻訳先言語ごとに使う音声を決める
+// ドライランではAWSを呼び出さないため、指定または既定の音声を使う
+func selectVoices(ctx context.Context, clients *Clients, cfg *Config) (map[string]string, error) {
+	var catalog *voiceCatalog
+	if !cfg.DryRun {
+		var err error
+		if catalog, err = loadVoiceCatalog(ctx, clients.Polly, cfg); err != nil {
+			return nil, fmt.Errorf("describing Polly voices: %w", err)
+		}
+	}
+	voices := make(map[string]string)
+	for _, lang := range cfg.TargetLangs {
+		var voice string
+		var err error
+		if catalog != nil {
+			voice, err = catalog.selectVoice(cfg, lang)
+		} else {
+			voice, err = voiceForLanguage(cfg, lang)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("selecting Polly voice for %s: %w", lang, err)
+		}
+		slog.Debug("selected Polly voice", "target_lang", lang, "voice", voice)
+		voices[lang] = voice
+	}
+	return voices, nil
+}
+
+// 1つの翻訳先言語に使う音声を決める
+// --voice や --voice-map で指定した音声はリージョンにあり、エンジンに対応しているか確認する
+// 指定がなければ既定音声、それも使えなければ言語が一致する最初の音声を選ぶ
+func (c *voiceCatalog) selectVoice(cfg *Config, lang string) (string, error) {
+	if voiceID := explicitVoice(cfg, lang); voiceID != "" {
+		voice, err := c.lookup(voiceID, cfg.Region)
+		if err != nil {
+			return "", err
+		}
+		if !supportsEngine(voice, cfg.Engine) {
+			return "", fmt.Errorf("voice %q does not support the %s engine (supported: %s)",
+				voiceID, cfg.Engine, strings.Join(aws.StringValueSlice(voice.SupportedEngines), ", "))
+		}
+		return voiceID, nil
+	}
+	if voiceID, err := voiceForLanguage(cfg, lang); err == nil {
+		if voice, ok := c.voices[voiceID]; ok && supportsEngine(voice, cfg.Engine) {
+			return voiceID, nil
+		}
+	}
+	ids := make([]string, 0, len(c.voices))
+	for id, voice := range c.voices {
+		if pollyLanguageMatches(aws.StringValue(voice.LanguageCode), lang) && supportsEngine(voice, cfg.Engine) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("no Polly voice for target language %q supports the %s engine in region %s (use --voice-map)", lang, cfg.Engine, cfg.Region)
+	}
+	sort.Strings(ids)
+	return ids[0], nil
+}
+
+// --voice または --voice-map で明示的に指定された音声（なければ空）
+func explicitVoice(cfg *Config, lang string) string {
+	if cfg.Voice != "" {
+		return cfg.Voice
+	}
+	return cfg.VoiceMap[lang]
+}
+
+// 音声が指定のエンジンに対応しているか
+func supportsEngine(voice *polly.Voice, engine string) bool {
+	for _, e := range voice.SupportedEngines {
+		if aws.StringValue(e) == engine {
+			return true
+		}
+	}
+	return false
+}
+
+// Polly の言語コード（en-US, cmn-CN など）が Translate の言語コード（en, zh, fr-CA など）に一致するか
+// 地域付きのコードは完全に一致する場合のみ、地域なしのコードは言語部分が一致すれば一致とみなす
+func pollyLanguageMatches(pollyLang, lang string) bool {
+	if strings.Contains(lang, "-") {
+		return strings.EqualFold(pollyLang, lang)
+	}
+	base, _, _ := strings.Cut(pollyLang, "-")
+	if alias, ok := pollyLanguageAliases[lang]; ok && base == alias {
+		return true
+	}
+	return base == lang
+}
+
+// 音声IDを検索する。見つからなければ利用可能な音声をいくつか挙げたエラーを返す
+func (c *voiceCatalog) lookup(voiceID, region string) (*polly.Voice, error) {
+	if v, ok := c.voices[voiceID]; ok {
+		return v, nil
+	}
+	ids := make([]string, 0, len(c.voices))
+	for id := range c.voices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if len(ids) > 10 {
+		ids = append(ids[:10], "...")
+	}
+	return nil, fmt.Errorf("voice %q is not available in region %s (available: %s)", voiceID, region, strings.Join(ids, ", "))
+}
+
+// Polly が1回の合成に適用できる発音辞書の数
+const maxLexicons = 5
+
+// 指定した発音辞書がリージョンに登録されているか確認する
+func checkLexicons(ctx context.Context, pollySvc PollyAPI, cfg *Config) error {
+	if len(cfg.Lexicons) == 0 {
+		return nil
+	}
+	var registered []string
+	input := &polly.ListLexiconsInput{}
+	for {
+		callCtx, cancel := callContext(ctx, cfg.Timeout)
+		output, err := pollySvc.ListLexiconsWithContext(callCtx, input)
+		cancel()
+		if err != nil {
+			return err
+		}
+		for _, l := range output.Lexicons {
+			registered = append(registered, aws.StringValue(l.Name))
+		}
+		if aws.StringValue(output.NextToken) == "" {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+	sort.Strings(registered)
+	for _, name := range cfg.Lexicons {
+		if slices.Contains(registered, name) {
+			continue
+		}
+		available := "none"
+		if len(registered) > 0 {
+			available = strings.Join(registered, ", ")
+		}
+		return fmt.Errorf("lexicon %q does not exist in region %s (available: %s)", name, cfg.Region, available)
+	}
+	return nil
+}
+
+// 音声合成とアップロードの結果
+type synthesisResult struct {
+	AudioKey  string // アップロード先のS3キー
+	LocalPath string // --keep-audio でローカルに残したファイル（残さない場合は空）
+	Reused    bool   // 同じ内容の音声がS3にあったため、合成せずに使い回したか
+}
+
+// 音声の内容を決める値から、S3キーに使うファイル名を作る
+// 同じテキスト・音声・形式なら毎回同じ名前になり、再実行時にS3の既存の音声を使い回せる
+func audioFileNameFor(cfg *Config, text, voice string) string {
+	h := sha256.New()
+	values := []string{text, voice, cfg.AudioFormat, cfg.Engine, cfg.TextType}
+	// 既存の音声の名前を変えないよう、--sample-rate・--lexicon を指定した場合だけ含める
+	// （辞書の中身を更新した場合は --force で作り直す）
+	if cfg.SampleRate != "" {
+		values = append(values, cfg.SampleRate)
+	}
+	if len(cfg.Lexicons) > 0 {
+		values = append(values, "lexicons="+strings.Join(cfg.Lexicons, ","))
+	}
+	for _, v := range values {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return audioFilePrefix + hex.EncodeToString(h.Sum(nil))[:32] + "-output" + audioFormats[cfg.AudioFormat].extension
+}
+
+// 合成した音声ファイル名の接頭辞
+const audioFilePrefix = "audioFile-"
+
+// S3にオブジェクトがあるか確認する
+func objectExists(ctx context.Context, s3Svc S3API, cfg *Config, key string) (bool, error) {
+	callCtx, cancel := callContext(ctx, cfg.Timeout)
+	defer cancel()
+	_, err := s3Svc.HeadObjectWithContext(callCtx, &s3.HeadObjectInput{
+		Bucket: aws.String(cfg.Bucket),
+		Key:    aws.String(key),
+	})
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// 翻訳結果をもとに合成音声による音声ファイルを作成し、S3にアップロードする
+// 同じ内容の音声がすでにS3にあれば、--force でない限り合成せずにそのキーを返す
+func synthesizeSpeechAndUpload(ctx context.Context, pollySvc PollyAPI, uploader UploaderAPI, s3Svc S3API, cfg *Config, text, voice string, labels objectLabels) (synthesisResult, error) {
+	format := audioFormats[cfg.AudioFormat]
+
+	speechText, err := prepareSpeechText(text, cfg.TextType)
+	if err != nil {
+		return synthesisResult{}, err
+	}
+
+	audioFileName := audioFileNameFor(cfg, speechText, voice)
+	audioKey := cfg.S3Prefix + audioFileName
+	if cfg.DryRun {
+		return synthesisResult{AudioKey: audioKey}, nil
+	}
+	if !cfg.Force {
+		exists, err := objectExists(ctx, s3Svc, cfg, audioKey)
+		if err != nil {
+			return synthesisResult{}, withStage(ErrUpload, fmt.Errorf("checking existing audio: %w", err))
+		}
+		if exists {
+			return synthesisResult{AudioKey: audioKey, Reused: true}, nil
+		}
+	}
+
+	// 合成音声の作成（長いテキストは複数回に分けて合成し、音声をつなげる）
+	chunks, err := speechChunks(speechText, cfg)
+	if err != nil {
+		return synthesisResult{}, err
+	}
+	inputs := make([]*polly.SynthesizeSpeechInput, len(chunks))
+	for i, chunk := range chunks {
+		inputs[i] = &polly.SynthesizeSpeechInput{
+			Text:         aws.String(chunk),
+			TextType:     aws.String(cfg.TextType),
+			OutputFormat: aws.String(pollyOutputFormat(cfg.AudioFormat)),
+			VoiceId:      aws.String(voice),
+			Engine:       aws.String(cfg.Engine),
+		}
+		if cfg.SampleRate != "" {
+			inputs[i].SampleRate = aws.String(cfg.SampleRate)
+		}
+		if len(cfg.Lexicons) > 0 {
+			inputs[i].LexiconNames = aws.StringSlice(cfg.Lexicons)
+		}
+	}
+	stream := &speechStream{ctx: ctx, pollySvc: pollySvc, cfg: cfg, inputs: inputs}
+	defer stream.Close()
+	// アップロードを始める前に最初の合成を行い、Polly のエラーをそのまま返す
+	if err := stream.next(); err != nil {
+		return synthesisResult{}, err
+	}
+
+	// 音声ストリームを一時ファイルを介さずにそのままS3へアップロードする（wav はヘッダーを作るためにメモリに読み込む）
+	// --keep-audio の場合はアップロードと同時にローカルにも書き出す
+	var body io.Reader = stream
+	var local io.Writer
+	if cfg.AudioFormat == audioFormatWav {
+		sampleRate, err := strconv.Atoi(cfg.SampleRate)
+		if err != nil {
+			return synthesisResult{}, fmt.Errorf("invalid sample rate %q: %w", cfg.SampleRate, err)
+		}
+		if body, err = wrapWAV(stream, sampleRate); err != nil {
+			return synthesisResult{}, err
+		}
+	}
+	var localPath string
+	var audioFile *os.File
+	kept := false
+	if cfg.KeepAudio {
+		// ファイル名は内容から決まるため、同名のファイルは同じ音声として上書きする
+		// 同じ行を並行して合成する場合に互いのファイルを壊さないよう、一時ファイルに書いてから名前を変える
+		localPath = filepath.Join(cfg.AudioDir, audioFileName)
+		audioFile, err = os.CreateTemp(cfg.AudioDir, "."+audioFileName+".*.tmp")
+		if err != nil {
+			return synthesisResult{}, err
+		}
+		// 一時ファイルは所有者だけが読める権限で作られるため、os.Create と同じ権限に戻す
+		if err := audioFile.Chmod(0o644); err != nil {
+			audioFile.Close()
+			os.Remove(audioFile.Name())
+			return synthesisResult{}, err
+		}
+		// 失敗や panic で抜けた場合も含めて、書き終えていない一時ファイルは閉じて削除する
+		defer func() {
+			if !kept {
+				audioFile.Close()
+				os.Remove(audioFile.Name())
+			}
+		}()
+		local = audioFile
+	}
+
+	input := newUploadInput(cfg, audioKey, nil, format.contentType)
+	labels.apply(input)
+	if err := uploadStream(ctx, uploader, cfg, input, body, local); err != nil {
+		return synthesisResult{}, err
+	}
+	if audioFile != nil {
+		// 書き込みの失敗は Close で分かることがあるため、ここで閉じて確かめる
+		if err := audioFile.Close(); err != nil {
+			return synthesisResult{}, fmt.Errorf("writing local audio file: %w", err)
+		}
+		if err := os.Rename(audioFile.Name(), localPath); err != nil {
+			return synthesisResult{}, fmt.Errorf("writing local audio file: %w", err)
+		}
+		kept = true
+	}
+	return synthesisResult{AudioKey: audioKey, LocalPath: localPath}, nil
+}
+
+// アップロードした音声をバケットを公開せずに共有するための、期限付きのダウンロードURLを作る
+// URL は S3 クライアントのリージョンのエンドポイントを使う
+func presignAudioURL(s3Svc S3API, cfg *Config, audioKey string) (string, error) {
+	req, _ := s3Svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(cfg.Bucket),
+		Key:    aws.String(audioKey),
+	})
+	return req.Presign(cfg.PresignExpiry)
+}
+
+// Polly が1回の SynthesizeSpeech で受け付ける文字数の上限
+// SSML はタグを除いた課金対象の文字数が maxSpeechCharacters、タグを含めた全体が maxSSMLCharacters まで
+const (
+	maxSpeechCharacters = 3000
+	maxSSMLCharacters   = 6000
+)
+
+// 合成するテキストを Polly の上限以下に分割する
+// SSML は <speak> の直下の要素（<s>・<p> など）の区切りで分け、それぞれを <speak> で囲む
+// ogg_vorbis は複数のストリームをつなげると再生できない環境があるため分割しない
+func speechChunks(text string, cfg *Config) ([]string, error) {
+	var chunks []string
+	if cfg.TextType == polly.TextTypeSsml {
+		var err error
+		if chunks, err = splitSSML(text); err != nil {
+			return nil, err
+		}
+	} else {
+		chunks = splitText(text, maxSpeechCharacters, utf8.RuneCountInString)
+	}
+	if len(chunks) > 1 && cfg.AudioFormat == polly.OutputFormatOggVorbis {
+		return nil, fmt.Errorf("text is %d characters, over Polly's %d-character limit; use --audio-format mp3, pcm or wav to synthesize it in parts",
+			utf8.RuneCountInString(text), maxSpeechCharacters)
+	}
+	return chunks, nil
+}
+
+// SSML の <speak> の直下の1要素（または要素の間のテキスト）
+type ssmlPiece struct {
+	raw    string
+	billed int // タグを除いた文字数
+}
+
+// <speak> で囲んだ SSML を、直下の要素の区切りで Polly の上限以下に分割する
+// 上限に収まればそのまま返し、直下の1要素だけで上限を超える場合はエラーにする
+func splitSSML(text string) ([]string, error) {
+	if utf8.RuneCountInString(text) <= maxSpeechCharacters {
+		return []string{text}, nil
+	}
+	decoder := xml.NewDecoder(strings.NewReader(text))
+	var open string
+	var pieces []ssmlPiece
+	depth, start, billed := 0, 0, 0
+	for {
+		before := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid SSML: %w", err)
+		}
+		after := int(decoder.InputOffset())
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch depth {
+			case 0:
+				open = text[:after]
+			case 1:
+				start, billed = before, 0
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 1 {
+				pieces = append(pieces, ssmlPiece{raw: text[start:after], billed: billed})
+			}
+		case xml.CharData:
+			if depth == 1 {
+				pieces = append(pieces, ssmlPiece{raw: text[before:after], billed: utf8.RuneCount(t)})
+			} else if depth > 1 {
+				billed += utf8.RuneCount(t)
+			}
+		default:
+			if depth == 1 {
+				pieces = append(pieces, ssmlPiece{raw: text[before:after]})
+			}
+		}
+	}
+
+	const closeTag = "</speak>"
+	wrapper := utf8.RuneCountInString(open) + len(closeTag)
+	var chunks []string
+	var current strings.Builder
+	currentBilled, currentTotal := 0, 0
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, open+current.String()+closeTag)
+			current.Reset()
+			currentBilled, currentTotal = 0, 0
+		}
+	}
+	for _, piece := range pieces {
+		total := utf8.RuneCountInString(piece.raw)
+		if piece.billed > maxSpeechCharacters || wrapper+total > maxSSMLCharacters {
+			return nil, fmt.Errorf("SSML element %q is over Polly's limit of %d characters (%d with tags); split it into smaller <s> or <p> elements",
+				snippet(strings.TrimSpace(piece.raw), lineSnippetRunes), maxSpeechCharacters, maxSSMLCharacters)
+		}
+		if currentBilled+piece.billed > maxSpeechCharacters || wrapper+currentTotal+total > maxSSMLCharacters {
+			flush()
+		}
+		current.WriteString(piece.raw)
+		currentBilled += piece.billed
+		currentTotal += total
+	}
+	flush()
+	return chunks, nil
+}
+
+// 複数回の合成結果を1つの音声として順に読み出す
+// mp3 はフレームの、pcm は生のサンプルの連続なので、そのままつなげて1つのファイルになる（wav はつなげた後にヘッダーを付ける）
+// 次の合成は前の音声を読み終えてから行い、各ストリームはその読み出しが終わるまで同じコンテキストを使う
+type speechStream struct {
+	ctx      context.Context
+	pollySvc PollyAPI
+	cfg      *Config
+	inputs   []*polly.SynthesizeSpeechInput
+
+	current io.ReadCloser
+	cancel  context.CancelFunc
+}
+
+func (s *speechStream) Read(p []byte) (int, error) {
+	for {
+		if s.current == nil {
+			if len(s.inputs) == 0 {
+				return 0, io.EOF
+			}
+			if err := s.next(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := s.current.Read(p)
+		if err == io.EOF {
+			s.closeCurrent()
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// 次のテキストを合成する
+func (s *speechStream) next() error {
+	input := s.inputs[0]
+	s.inputs = s.inputs[1:]
+	var output *polly.SynthesizeSpeechOutput
+	cancel := func() {}
+	err := withRetry(s.ctx, s.cfg, func() error {
+		cancel()
+		var callCtx context.Context
+		callCtx, cancel = callContext(s.ctx, s.cfg.Timeout)
+		var err error
+		output, err = s.pollySvc.SynthesizeSpeechWithContext(callCtx, input)
+		return err
+	})
+	if err != nil {
+		cancel()
+		return err
+	}
+	s.current, s.cancel = output.AudioStream, cancel
+	return nil
+}
+
+func (s *speechStream) closeCurrent() {
+	if s.current != nil {
+		s.current.Close()
+		s.cancel()
+		s.current, s.cancel = nil, nil
+	}
+}
+
+func (s *speechStream) Close() error {
+	s.closeCurrent()
+	return nil
+}
+
+// Polly に渡すテキストを整える
+// SSMLの場合は<speak>で囲み、タグの対応が取れているかを送信前に確認する
+func prepareSpeechText(text, textType string) (string, error) {
+	if textType != polly.TextTypeSsml {
+		return text, nil
+	}
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "<speak") || !strings.HasSuffix(trimmed, "</speak>") {
+		trimmed = "<speak>" + trimmed + "</speak>"
+	}
+	decoder := xml.NewDecoder(strings.NewReader(trimmed))
+	for {
+		if _, err := decoder.Token(); err != nil {
+			if err == io.EOF {
+				return trimmed, nil
+			}
+			return "", fmt.Errorf("invalid SSML: %w", err)
+		}
+	}
+}
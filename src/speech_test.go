@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// SSML のタグを除いた文字数
+func ssmlBilled(t *testing.T, ssml string) int {
+	t.Helper()
+	decoder := xml.NewDecoder(strings.NewReader(ssml))
+	n := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return n
+		}
+		if err != nil {
+			t.Fatalf("invalid SSML chunk %q: %v", snippet(ssml, 40), err)
+		}
+		if data, ok := token.(xml.CharData); ok {
+			n += utf8.RuneCount(data)
+		}
+	}
+}
+
+func TestSplitSSML(t *testing.T) {
+	sentence := "<s>" + strings.Repeat("あ", 99) + "。</s>"
+	long := `<speak xml:lang="ja-JP">` + strings.Repeat(sentence, 40) + "</speak>"
+
+	tests := []struct {
+		name      string
+		text      string
+		wantParts int
+		wantErr   bool
+	}{
+		{name: "short", text: "<speak><s>こんにちは</s></speak>", wantParts: 1},
+		{name: "long", text: long, wantParts: 2},
+		{name: "oversized element", text: "<speak><p>" + strings.Repeat("あ", maxSpeechCharacters+1) + "</p></speak>", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := splitSSML(tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("splitSSML: got %d chunks, want an error", len(chunks))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitSSML: %v", err)
+			}
+			if len(chunks) != tt.wantParts {
+				t.Fatalf("got %d chunks, want %d", len(chunks), tt.wantParts)
+			}
+			var inner strings.Builder
+			open := tt.text[:strings.Index(tt.text, ">")+1]
+			for _, chunk := range chunks {
+				if billed := ssmlBilled(t, chunk); billed > maxSpeechCharacters {
+					t.Errorf("chunk has %d billed characters, over %d", billed, maxSpeechCharacters)
+				}
+				if n := utf8.RuneCountInString(chunk); n > maxSSMLCharacters {
+					t.Errorf("chunk has %d characters, over %d", n, maxSSMLCharacters)
+				}
+				if !strings.HasPrefix(chunk, open) || !strings.HasSuffix(chunk, "</speak>") {
+					t.Errorf("chunk %q is not wrapped in the original <speak>", snippet(chunk, 40))
+				}
+				inner.WriteString(strings.TrimSuffix(strings.TrimPrefix(chunk, open), "</speak>"))
+			}
+			if got := open + inner.String() + "</speak>"; got != tt.text {
+				t.Errorf("chunks do not reassemble to the input")
+			}
+		})
+	}
+}
+
+func TestSynthesizeSpeechAndUpload(t *testing.T) {
+	denied := awserr.New("AccessDenied", "denied", nil)
+	tests := []struct {
+		name        string
+		args        []string
+		pollyErrs   []error
+		uploadErr   error
+		exists      bool
+		wantCalls   int
+		wantReused  bool
+		wantErr     error
+		wantUpload  bool // ErrUpload を付けて返すか
+		wantUploads int
+	}{
+		{name: "success", wantCalls: 1, wantUploads: 1},
+		{name: "throttled then success", pollyErrs: []error{errThrottled}, wantCalls: 2, wantUploads: 1},
+		{name: "synthesis fails", pollyErrs: []error{denied}, wantCalls: 1, wantErr: denied},
+		{name: "upload fails", uploadErr: denied, wantCalls: 1, wantErr: denied, wantUpload: true},
+		{name: "reuses existing audio", args: []string{"--force=false"}, exists: true, wantReused: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, append([]string{"--bucket", "bucket", "--force"}, tt.args...)...)
+			pollySvc := &fakePolly{failures: failures{errs: tt.pollyErrs}}
+			uploader := &fakeUploader{err: tt.uploadErr}
+			key := cfg.S3Prefix + audioFileNameFor(cfg, "Hello", "Joanna")
+			s3Svc := &fakeS3{exists: map[string]bool{key: tt.exists}}
+
+			got, err := synthesizeSpeechAndUpload(context.Background(), pollySvc, uploader, s3Svc, cfg, "Hello", "Joanna", objectLabels{})
+			if calls := pollySvc.count(); calls != tt.wantCalls {
+				t.Errorf("SynthesizeSpeech called %d times, want %d", calls, tt.wantCalls)
+			}
+			if len(uploader.uploads) != tt.wantUploads {
+				t.Errorf("uploaded %d objects, want %d", len(uploader.uploads), tt.wantUploads)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				if errors.Is(err, ErrUpload) != tt.wantUpload {
+					t.Errorf("errors.Is(err, ErrUpload) = %v, want %v", !tt.wantUpload, tt.wantUpload)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("synthesizeSpeechAndUpload: %v", err)
+			}
+			if got.AudioKey != key || got.Reused != tt.wantReused {
+				t.Errorf("got %+v, want key %s reused %v", got, key, tt.wantReused)
+			}
+			if tt.wantUploads > 0 {
+				upload := uploader.uploads[0]
+				if ct := aws.StringValue(upload.ContentType); ct != "audio/mpeg" {
+					t.Errorf("content type %q, want audio/mpeg", ct)
+				}
+				if size := uploader.sizes[key]; size != int64(len("Hello")) {
+					t.Errorf("uploaded %d bytes, want %d", size, len("Hello"))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSynthesizeSpeechAndUpload(b *testing.B) {
+	cfg := testConfig(b, "--bucket", "bucket", "--force")
+	const audioSize = 1 << 20
+	pollySvc := &fakePolly{size: audioSize}
+	b.SetBytes(audioSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := synthesizeSpeechAndUpload(context.Background(), pollySvc, discardUploader{}, &fakeS3{}, cfg, "Hello", "Joanna", objectLabels{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSynthesizeSpeechKeepAudio(t *testing.T) {
+	denied := awserr.New("AccessDenied", "denied", nil)
+	tests := []struct {
+		name      string
+		uploadErr error
+		wantKept  bool
+	}{
+		{name: "kept after upload", wantKept: true},
+		{name: "removed when upload fails", uploadErr: denied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := testConfig(t, "--bucket", "bucket", "--force", "--keep-audio", "--audio-dir", dir)
+			got, err := synthesizeSpeechAndUpload(context.Background(), &fakePolly{}, &fakeUploader{err: tt.uploadErr}, &fakeS3{}, cfg, "Hello", "Joanna", objectLabels{})
+			if (err == nil) != tt.wantKept {
+				t.Fatalf("synthesizeSpeechAndUpload: %v", err)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantKept {
+				if len(entries) != 0 {
+					t.Errorf("left %d files in --audio-dir after a failed upload", len(entries))
+				}
+				return
+			}
+			if got.LocalPath != filepath.Join(dir, audioFileNameFor(cfg, "Hello", "Joanna")) {
+				t.Errorf("LocalPath %q", got.LocalPath)
+			}
+			if data, err := os.ReadFile(got.LocalPath); err != nil || string(data) != "Hello" {
+				t.Errorf("kept audio = %q, %v; want the synthesized audio", data, err)
+			}
+		})
+	}
+}
+
+func TestSynthesizeSpeechKeepAudioConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testConfig(t, "--bucket", "bucket", "--force", "--keep-audio", "--audio-dir", dir)
+	denied := awserr.New("AccessDenied", "denied", nil)
+	const audioSize = 1 << 20
+
+	// 同じ行の合成が並行して走り、片方だけが失敗しても、成功した方の音声は残る
+	for i := 0; i < 20; i++ {
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for j, uploader := range []*fakeUploader{{}, {err: denied}} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[j] = synthesizeSpeechAndUpload(context.Background(), &fakePolly{size: audioSize}, uploader, &fakeS3{}, cfg, "Hello", "Joanna", objectLabels{})
+			}()
+		}
+		wg.Wait()
+		if errs[0] != nil || !errors.Is(errs[1], denied) {
+			t.Fatalf("got %v and %v, want success and the upload error", errs[0], errs[1])
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != audioFileNameFor(cfg, "Hello", "Joanna") {
+			t.Fatalf("run %d: --audio-dir has %d entries, want only the kept audio", i, len(entries))
+		}
+		info, err := entries[0].Info()
+		if err != nil || info.Size() != audioSize || info.Mode().Perm() != 0o644 {
+			t.Fatalf("run %d: kept audio is %v, %v; want %d bytes with mode 0644", i, info, err, audioSize)
+		}
+		os.Remove(filepath.Join(dir, entries[0].Name()))
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/polly"
+)
+
+// スピーチマークを書き出すファイルの拡張子（1行に1件のJSON）
+const speechMarksExtension = ".marks.json"
+
+// スピーチマークの Content-Type
+const speechMarksContentType = "application/x-ndjson"
+
+// スピーチマークの作成結果
+type speechMarksResult struct {
+	File string // ローカルに書き出したファイル
+	Key  string // --speech-marks-to-s3 でアップロードしたキー（アップロードしない場合は空）
+}
+
+// 音声と同じ内容でスピーチマーク（単語・文などの再生位置）を合成し、音声と同じ名前で書き出す
+// 音声とは別の SynthesizeSpeech 呼び出しになる
+func synthesizeSpeechMarks(ctx context.Context, pollySvc PollyAPI, uploader UploaderAPI, cfg *Config, text, voice, audioKey string) (speechMarksResult, error) {
+	name := strings.TrimSuffix(path.Base(audioKey), path.Ext(audioKey)) + speechMarksExtension
+	result := speechMarksResult{File: filepath.Join(cfg.AudioDir, name)}
+	if cfg.SpeechMarksToS3 {
+		result.Key = cfg.S3Prefix + name
+	}
+	if cfg.DryRun {
+		return result, nil
+	}
+
+	speechText, err := prepareSpeechText(text, cfg.TextType)
+	if err != nil {
+		return speechMarksResult{}, err
+	}
+	// 分割して合成すると位置が分割ごとに0から数え直しになるため、1回で合成できる長さに限る
+	if cfg.TextType != polly.TextTypeSsml && utf8.RuneCountInString(speechText) > maxSpeechCharacters {
+		return speechMarksResult{}, fmt.Errorf("text is %d characters, over Polly's %d-character limit for speech marks", utf8.RuneCountInString(speechText), maxSpeechCharacters)
+	}
+	input := &polly.SynthesizeSpeechInput{
+		Text:            aws.String(speechText),
+		TextType:        aws.String(cfg.TextType),
+		OutputFormat:    aws.String(polly.OutputFormatJson),
+		SpeechMarkTypes: aws.StringSlice(cfg.SpeechMarks),
+		VoiceId:         aws.String(voice),
+		Engine:          aws.String(cfg.Engine),
+	}
+	if len(cfg.Lexicons) > 0 {
+		input.LexiconNames = aws.StringSlice(cfg.Lexicons)
+	}
+	var marks []byte
+	err = withRetry(ctx, cfg, func() error {
+		callCtx, cancel := callContext(ctx, cfg.Timeout)
+		defer cancel()
+		output, err := pollySvc.SynthesizeSpeechWithContext(callCtx, input)
+		if err != nil {
+			return err
+		}
+		defer output.AudioStream.Close()
+		marks, err = io.ReadAll(output.AudioStream)
+		return err
+	})
+	if err != nil {
+		return speechMarksResult{}, err
+	}
+
+	if err := os.WriteFile(result.File, marks, 0o644); err != nil {
+		return speechMarksResult{}, err
+	}
+	if result.Key != "" {
+		uploadCtx, cancel := callContext(ctx, cfg.Timeout)
+		defer cancel()
+		if _, err := uploader.UploadWithContext(uploadCtx, newUploadInput(cfg, result.Key, bytes.NewReader(marks), speechMarksContentType)); err != nil {
+			return speechMarksResult{}, fmt.Errorf("uploading speech marks: %w", err)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 文の終わりとみなす文字（文字の直後で区切る）
+const sentenceTerminators = ".!?。！？"
+
+// 1文が長すぎる場合に次に区切る、節の終わりとみなす文字
+const clauseTerminators = ",;:、，；："
+
+// 長いテキストを size で測って limit 以下の塊に分割する
+// できるだけ文の区切りで分け、1文が limit を超える場合は節の区切り、それでも超える場合は文字単位で分ける
+// 分割した塊をそのままつなげると元のテキストに戻る
+func splitText(text string, limit int, size func(string) int) []string {
+	if size(text) <= limit {
+		return []string{text}
+	}
+	return splitOn(text, limit, size, []string{sentenceTerminators, clauseTerminators})
+}
+
+// terminators[0] の区切りでテキストを分け、limit を超える部分は残りの区切りで分ける
+func splitOn(text string, limit int, size func(string) int, terminators []string) []string {
+	if len(terminators) == 0 {
+		return splitRunes(text, limit, size)
+	}
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+	for _, segment := range splitAfter(text, terminators[0]) {
+		if size(segment) > limit {
+			flush()
+			chunks = append(chunks, splitOn(segment, limit, size, terminators[1:])...)
+			continue
+		}
+		if size(current.String()+segment) > limit {
+			flush()
+		}
+		current.WriteString(segment)
+	}
+	flush()
+	return chunks
+}
+
+// テキストを区切り文字の直後で分ける（区切り文字と、その後の空白は前の部分に含める）
+func splitAfter(text, terminators string) []string {
+	var segments []string
+	start := 0
+	for i := 0; i < len(text); {
+		r, n := utf8.DecodeRuneInString(text[i:])
+		i += n
+		if !strings.ContainsRune(terminators, r) {
+			continue
+		}
+		for i < len(text) {
+			r, n := utf8.DecodeRuneInString(text[i:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			i += n
+		}
+		segments = append(segments, text[start:i])
+		start = i
+	}
+	if start < len(text) {
+		segments = append(segments, text[start:])
+	}
+	return segments
+}
+
+// 区切りのない長い部分を、マルチバイト文字の途中で切らないように limit 以下に分ける
+func splitRunes(text string, limit int, size func(string) int) []string {
+	var chunks []string
+	start := 0
+	for i := 0; i < len(text); {
+		_, n := utf8.DecodeRuneInString(text[i:])
+		if i > start && size(text[start:i+n]) > limit {
+			chunks = append(chunks, text[start:i])
+			start = i
+		}
+		i += n
+	}
+	return append(chunks, text[start:])
+}
+
+// 分割して翻訳した塊をつなげる
+// 訳文が全角の句点で終わる場合や、つなぎ目が漢字・かなの場合（日本語・中国語など）以外は、間に空白を入れる
+func joinChunks(translated []string) string {
+	var b strings.Builder
+	for i, t := range translated {
+		b.WriteString(t)
+		if i == len(translated)-1 || t == "" {
+			continue
+		}
+		last, _ := utf8.DecodeLastRuneInString(t)
+		next, _ := utf8.DecodeRuneInString(translated[i+1])
+		if !unicode.IsSpace(last) && !strings.ContainsRune("。！？", last) && !isCJK(last) && !isCJK(next) {
+			b.WriteString(" ")
+		}
+	}
+	return b.String()
+}
+
+// 単語の間に空白を入れない文字（漢字・ひらがな・カタカナ）か
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitTextLongJapaneseLine(t *testing.T) {
+	// 1文が上限を超える部分を含む、10KB を超える日本語の1行
+	long := strings.Repeat("あいうえお", 800) + "。"
+	line := strings.Repeat("今日は良い天気ですね、散歩に行きましょう。", 200) + long + strings.Repeat("猫が好きです！", 100)
+	if len(line) <= maxTranslateBytes {
+		t.Fatalf("test line is only %d bytes", len(line))
+	}
+
+	chunks := splitText(line, maxTranslateBytes, func(s string) int { return len(s) })
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want the line split", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > maxTranslateBytes {
+			t.Errorf("chunk %d is %d bytes, over %d", i, len(chunk), maxTranslateBytes)
+		}
+		if !utf8.ValidString(chunk) {
+			t.Errorf("chunk %d splits a multi-byte character", i)
+		}
+	}
+	if got := joinChunks(chunks); got != line {
+		t.Errorf("joinChunks does not restore the line (got %d bytes, want %d)", len(got), len(line))
+	}
+}
+
+func BenchmarkSplitText(b *testing.B) {
+	// 区切りの少ない長い1行（約1MB）
+	line := strings.Repeat("吾輩は猫である、名前はまだ無い"+strings.Repeat("あ", 500)+"。", 600)
+	size := func(s string) int { return len(s) }
+	b.SetBytes(int64(len(line)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		splitText(line, maxTranslateBytes, size)
+	}
+}
+
+func TestJoinChunks(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{name: "english", chunks: []string{"Hello.", "World."}, want: "Hello. World."},
+		{name: "trailing space", chunks: []string{"Hello. ", "World."}, want: "Hello. World."},
+		{name: "japanese sentence", chunks: []string{"こんにちは。", "さようなら。"}, want: "こんにちは。さようなら。"},
+		{name: "japanese mid-sentence", chunks: []string{"あいう", "えお"}, want: "あいうえお"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := joinChunks(tt.chunks); got != tt.want {
+				t.Errorf("joinChunks(%q) = %q, want %q", tt.chunks, got, tt.want)
+			}
+		})
+	}
+}
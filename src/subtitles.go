@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// --subtitles の値
+const (
+	subtitleSRT = "srt"
+	subtitleVTT = "vtt"
+)
+
+// この長さ以上の間があれば、文字数に余裕があっても次の字幕に分ける
+const subtitlePauseGap = 800 * time.Millisecond
+
+// 字幕1つ分
+type subtitleCue struct {
+	start, end time.Duration
+	text       string
+}
+
+// 単語のタイムスタンプから字幕を組み立てる
+// 字幕は間（ポーズ）か maxChars を超えるところで区切り、句読点は直前の単語に付ける
+func buildCues(items []transcriptItem, maxChars int) ([]subtitleCue, error) {
+	var cues []subtitleCue
+	var current *subtitleCue
+	for _, it := range items {
+		content := it.content()
+		if it.Type == "punctuation" {
+			if current != nil {
+				current.text += content
+			}
+			continue
+		}
+		start, err := parseItemTime(it.StartTime)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseItemTime(it.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil && (start-current.end >= subtitlePauseGap ||
+			utf8.RuneCountInString(current.text)+1+utf8.RuneCountInString(content) > maxChars) {
+			cues = append(cues, *current)
+			current = nil
+		}
+		if current == nil {
+			current = &subtitleCue{start: start, end: end, text: content}
+			continue
+		}
+		current.text += " " + content
+		current.end = end
+	}
+	if current != nil {
+		cues = append(cues, *current)
+	}
+	return cues, nil
+}
+
+// 結果JSONの秒数（"1.23" など）を読む
+func parseItemTime(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q: %w", s, err)
+	}
+	return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond), nil
+}
+
+// 字幕を SRT か WebVTT の形式にする
+func formatSubtitles(cues []subtitleCue, format string) string {
+	var b strings.Builder
+	// SRT はミリ秒の前がカンマ、VTT はドット
+	sep := ","
+	if format == subtitleVTT {
+		sep = "."
+		b.WriteString("WEBVTT\n\n")
+	}
+	for i, cue := range cues {
+		if format == subtitleSRT {
+			fmt.Fprintf(&b, "%d\n", i+1)
+		}
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", formatCueTime(cue.start, sep), formatCueTime(cue.end, sep), cue.text)
+	}
+	return b.String()
+}
+
+// 時刻を hh:mm:ss<sep>mmm の形にする
+func formatCueTime(d time.Duration, sep string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/transcribeservice"
+)
+
+// 翻訳先言語ごとに、読み上げた音声を文字起こしする言語
+// （地域付きの翻訳先言語はそのまま、なければ言語部分で引く）
+var defaultTranscribeLanguages = map[string]string{
+	"ar":    "ar-SA",
+	"da":    "da-DK",
+	"de":    "de-DE",
+	"en":    "en-US",
+	"es":    "es-ES",
+	"es-MX": "es-US",
+	"fr":    "fr-FR",
+	"fr-CA": "fr-CA",
+	"hi":    "hi-IN",
+	"it":    "it-IT",
+	"ja":    "ja-JP",
+	"ko":    "ko-KR",
+	"nl":    "nl-NL",
+	"pl":    "pl-PL",
+	"pt":    "pt-BR",
+	"pt-PT": "pt-PT",
+	"ru":    "ru-RU",
+	"sv":    "sv-SE",
+	"tr":    "tr-TR",
+	"zh":    "zh-CN",
+	"zh-TW": "zh-TW",
+}
+
+// 音声ファイルの拡張子ごとの Transcribe のメディア形式
+var mediaFormatsByExtension = map[string]string{
+	".amr":  transcribeservice.MediaFormatAmr,
+	".flac": transcribeservice.MediaFormatFlac,
+	".m4a":  transcribeservice.MediaFormatM4a,
+	".mp3":  transcribeservice.MediaFormatMp3,
+	".mp4":  transcribeservice.MediaFormatMp4,
+	".ogg":  transcribeservice.MediaFormatOgg,
+	".wav":  transcribeservice.MediaFormatWav,
+	".webm": transcribeservice.MediaFormatWebm,
+}
+
+// 音声ファイルの拡張子から Transcribe のメディア形式を決める
+func mediaFormatFor(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if format, ok := mediaFormatsByExtension[ext]; ok {
+		return format, nil
+	}
+	if ext == "" {
+		return "", fmt.Errorf("cannot tell the media format of %s: no file extension", path)
+	}
+	return "", fmt.Errorf("Transcribe does not accept %s audio (%s)", strings.TrimPrefix(ext, "."), path)
+}
+
+// 翻訳先言語の音声を文字起こしする言語を返す
+// --transcribe-auto-detect の場合は自動判定させるため空を返す
+func transcribeLanguageFor(cfg *Config, targetLang string) (string, error) {
+	if cfg.TranscribeAutoDetect {
+		return "", nil
+	}
+	if cfg.TranscribeLanguage != "" {
+		return cfg.TranscribeLanguage, nil
+	}
+	if lang, ok := defaultTranscribeLanguages[targetLang]; ok {
+		return lang, nil
+	}
+	if base, _, found := strings.Cut(targetLang, "-"); found {
+		if lang, ok := defaultTranscribeLanguages[base]; ok {
+			return lang, nil
+		}
+	}
+	return "", fmt.Errorf("no Transcribe language configured for target language %q (use --transcribe-language or --transcribe-auto-detect)", targetLang)
+}
+
+// 文字起こしの結果
+type transcriptionResult struct {
+	JobName       string
+	TranscriptURI string        // 結果JSONのURI（ドライランでは空）
+	LanguageCode  string        // 文字起こしした言語（自動判定の場合は判定結果、ドライランでは空）
+	StartDuration time.Duration // ジョブの開始にかかった時間
+	WaitDuration  time.Duration // ジョブの完了までの待ち時間
+}
+
+// 文字起こしする音声の指定
+type transcriptionRequest struct {
+	MediaURI     string               // s3://bucket/key
+	LanguageCode string               // en-US など（空なら言語を自動判定する）
+	MediaFormat  string               // mp3 など
+	OnStarted    func(jobName string) // ジョブを開始したときに呼ぶ（nil なら呼ばない）
+}
+
+// アップロードした音声ファイルを文字起こしする（Transcribeを使う）
+// ジョブの完了まで待つ（onStarted はジョブを開始したときに呼ぶ。nil でもよい）
+func transcribeAudioFile(ctx context.Context, transcribeSvc TranscribeAPI, cfg *Config, audioKey, targetLang string, onStarted func(jobName string)) (transcriptionResult, error) {
+	languageCode, err := transcribeLanguageFor(cfg, targetLang)
+	if err != nil {
+		return transcriptionResult{}, err
+	}
+	mediaFormat, err := mediaFormatFor(audioKey)
+	if err != nil {
+		return transcriptionResult{}, err
+	}
+	return transcribeMedia(ctx, transcribeSvc, cfg, transcriptionRequest{
+		MediaURI:     fmt.Sprintf("s3://%s/%s", cfg.Bucket, audioKey),
+		LanguageCode: languageCode,
+		MediaFormat:  mediaFormat,
+		OnStarted:    onStarted,
+	})
+}
+
+// S3上の音声を文字起こしし、ジョブの完了まで待つ
+// 結果JSONは --bucket の --transcribe-output-prefix の下に置く
+func transcribeMedia(ctx context.Context, transcribeSvc TranscribeAPI, cfg *Config, req transcriptionRequest) (transcriptionResult, error) {
+	transcriptionJobName := newTranscriptionJobName(cfg.JobPrefix)
+	if cfg.DryRun {
+		if req.OnStarted != nil {
+			req.OnStarted(transcriptionJobName)
+		}
+		return transcriptionResult{JobName: transcriptionJobName}, nil
+	}
+	transcribeInput := &transcribeservice.StartTranscriptionJobInput{
+		TranscriptionJobName: aws.String(transcriptionJobName),
+		MediaFormat:          aws.String(req.MediaFormat),
+		Media: &transcribeservice.Media{
+			MediaFileUri: aws.String(req.MediaURI),
+		},
+		OutputBucketName: aws.String(cfg.Bucket),
+		OutputKey:        aws.String(transcriptOutputKey(cfg.TranscribeOutputPrefix, transcriptionJobName)),
+	}
+	if req.LanguageCode != "" {
+		transcribeInput.LanguageCode = aws.String(req.LanguageCode)
+	} else {
+		transcribeInput.IdentifyLanguage = aws.Bool(true)
+		if len(cfg.TranscribeLanguageOptions) > 0 {
+			transcribeInput.LanguageOptions = aws.StringSlice(cfg.TranscribeLanguageOptions)
+		}
+	}
+	if settings := transcriptionSettings(cfg); settings != nil {
+		transcribeInput.Settings = settings
+	}
+	// Transcribe の出力は KMS キーの指定のみ可能（それ以外はバケットの既定の暗号化に従う）
+	if cfg.KMSKeyID != "" {
+		transcribeInput.OutputEncryptionKMSKeyId = aws.String(cfg.KMSKeyID)
+	}
+
+	started := time.Now()
+	err := withRetry(ctx, cfg, func() error {
+		callCtx, cancel := callContext(ctx, cfg.Timeout)
+		defer cancel()
+		_, err := transcribeSvc.StartTranscriptionJobWithContext(callCtx, transcribeInput)
+		return err
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == transcribeservice.ErrCodeConflictException {
+		return transcriptionResult{}, fmt.Errorf("transcription job name %s already exists (try a different --job-prefix): %w", transcriptionJobName, err)
+	}
+	if err != nil {
+		return transcriptionResult{}, err
+	}
+
+	startDuration := time.Since(started)
+	if req.OnStarted != nil {
+		req.OnStarted(transcriptionJobName)
+	}
+
+	started = time.Now()
+	job, err := waitForTranscriptionJob(ctx, transcribeSvc, cfg, transcriptionJobName)
+	if err != nil {
+		return transcriptionResult{}, err
+	}
+	return transcriptionResult{
+		JobName:       transcriptionJobName,
+		TranscriptURI: aws.StringValue(job.Transcript.TranscriptFileUri),
+		LanguageCode:  aws.StringValue(job.LanguageCode),
+		StartDuration: startDuration,
+		WaitDuration:  time.Since(started),
+	}, nil
+}
+
+// --vocabulary-name などのジョブの設定（指定がなければ nil）
+func transcriptionSettings(cfg *Config) *transcribeservice.Settings {
+	if cfg.VocabularyName == "" && !cfg.ShowSpeakers && !cfg.ChannelIdentification {
+		return nil
+	}
+	settings := &transcribeservice.Settings{}
+	if cfg.VocabularyName != "" {
+		settings.VocabularyName = aws.String(cfg.VocabularyName)
+	}
+	if cfg.ShowSpeakers {
+		settings.ShowSpeakerLabels = aws.Bool(true)
+		settings.MaxSpeakerLabels = aws.Int64(int64(cfg.MaxSpeakers))
+	}
+	if cfg.ChannelIdentification {
+		settings.ChannelIdentification = aws.Bool(true)
+	}
+	return settings
+}
+
+// 文字起こしジョブを新しい順に最大 limit 件取得する（limit が0なら全て）
+// 名前は prefix で始まるものだけに絞る（API は部分一致しか指定できないため手元でも確かめる）
+func listTranscriptionJobs(ctx context.Context, transcribeSvc TranscribeAPI, cfg *Config, status, prefix string, limit int) ([]*transcribeservice.TranscriptionJobSummary, error) {
+	input := &transcribeservice.ListTranscriptionJobsInput{MaxResults: aws.Int64(100)}
+	if status != "" {
+		input.Status = aws.String(status)
+	}
+	if prefix != "" {
+		input.JobNameContains = aws.String(prefix)
+	}
+	var jobs []*transcribeservice.TranscriptionJobSummary
+	for {
+		var output *transcribeservice.ListTranscriptionJobsOutput
+		err := withRetry(ctx, cfg, func() error {
+			callCtx, cancel := callContext(ctx, cfg.Timeout)
+			defer cancel()
+			var err error
+			output, err = transcribeSvc.ListTranscriptionJobsWithContext(callCtx, input)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, job := range output.TranscriptionJobSummaries {
+			if !strings.HasPrefix(aws.StringValue(job.TranscriptionJobName), prefix) {
+				continue
+			}
+			jobs = append(jobs, job)
+			if limit > 0 && len(jobs) == limit {
+				return jobs, nil
+			}
+		}
+		if aws.StringValue(output.NextToken) == "" {
+			return jobs, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// プロセス内で作成したジョブの通し番号
+var jobCounter atomic.Int64
+
+// 衝突しない文字起こしジョブ名を作る（時刻・通し番号・ランダムな接尾辞を含める）
+func newTranscriptionJobName(prefix string) string {
+	return fmt.Sprintf("%s-%s-%d-%s", prefix, time.Now().Format("20060102150405"), jobCounter.Add(1), uniqueSuffix())
+}
+
+// 文字起こしジョブが COMPLETED か FAILED になるまでポーリングする
+func waitForTranscriptionJob(ctx context.Context, transcribeSvc TranscribeAPI, cfg *Config, jobName string) (*transcribeservice.TranscriptionJob, error) {
+	if cfg.TranscribeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.TranscribeTimeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		var output *transcribeservice.GetTranscriptionJobOutput
+		err := withRetry(ctx, cfg, func() error {
+			callCtx, cancel := callContext(ctx, cfg.Timeout)
+			defer cancel()
+			var err error
+			output, err = transcribeSvc.GetTranscriptionJobWithContext(callCtx, &transcribeservice.GetTranscriptionJobInput{
+				TranscriptionJobName: aws.String(jobName),
+			})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		job := output.TranscriptionJob
+		switch aws.StringValue(job.TranscriptionJobStatus) {
+		case transcribeservice.TranscriptionJobStatusCompleted:
+			return job, nil
+		case transcribeservice.TranscriptionJobStatusFailed:
+			return nil, fmt.Errorf("transcription job %s failed: %s", jobName, aws.StringValue(job.FailureReason))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for transcription job %s: %w", jobName, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
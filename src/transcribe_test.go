@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/transcribeservice"
+)
+
+func TestTranscribeAudioFile(t *testing.T) {
+	const (
+		inProgress = transcribeservice.TranscriptionJobStatusInProgress
+		completed  = transcribeservice.TranscriptionJobStatusCompleted
+		failed     = transcribeservice.TranscriptionJobStatusFailed
+	)
+	tests := []struct {
+		name       string
+		startErrs  []error
+		statuses   []string
+		wantStarts int
+		wantErr    string
+	}{
+		{name: "completed", statuses: []string{inProgress, inProgress, completed}, wantStarts: 1},
+		{name: "start throttled", startErrs: []error{errThrottled}, statuses: []string{completed}, wantStarts: 2},
+		{name: "job failed", statuses: []string{inProgress, failed}, wantStarts: 1, wantErr: "unsupported audio"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, "--bucket", "bucket")
+			svc := &fakeTranscribe{starts: failures{errs: tt.startErrs}, statuses: tt.statuses, failureReason: "unsupported audio"}
+			var startedJob string
+			got, err := transcribeAudioFile(context.Background(), svc, cfg, "audio.mp3", "en", func(jobName string) { startedJob = jobName })
+			if calls := svc.starts.count(); calls != tt.wantStarts {
+				t.Errorf("StartTranscriptionJob called %d times, want %d", calls, tt.wantStarts)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("transcribeAudioFile: %v", err)
+			}
+			if startedJob == "" || got.JobName != startedJob {
+				t.Errorf("started job %q, result job %q", startedJob, got.JobName)
+			}
+			if want := "s3://bucket/" + got.JobName + ".json"; got.TranscriptURI != want {
+				t.Errorf("transcript URI %q, want %q", got.TranscriptURI, want)
+			}
+			input := svc.started[0]
+			if uri := aws.StringValue(input.Media.MediaFileUri); uri != "s3://bucket/audio.mp3" {
+				t.Errorf("media URI %q", uri)
+			}
+			if format := aws.StringValue(input.MediaFormat); format != transcribeservice.MediaFormatMp3 {
+				t.Errorf("media format %q, want mp3", format)
+			}
+			if lang := aws.StringValue(input.LanguageCode); lang != "en-US" {
+				t.Errorf("language %q, want en-US", lang)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Transcribe が出力する結果JSONの構造（使用する項目のみ）
+type transcribeResult struct {
+	JobName string `json:"jobName"`
+	Results struct {
+		Transcripts []struct {
+			Transcript string `json:"transcript"`
+		} `json:"transcripts"`
+		Items         []transcriptItem `json:"items"`
+		SpeakerLabels struct {
+			Segments []speakerSegment `json:"segments"`
+		} `json:"speaker_labels"` // --show-speakers の場合のみ
+	} `json:"results"`
+}
+
+// 結果JSONの単語または句読点1つ分
+type transcriptItem struct {
+	Type         string `json:"type"`       // pronunciation または punctuation
+	StartTime    string `json:"start_time"` // 秒（punctuation にはない）
+	EndTime      string `json:"end_time"`
+	SpeakerLabel string `json:"speaker_label"` // --show-speakers の場合のみ（spk_0 など）
+	Alternatives []struct {
+		Confidence string `json:"confidence"`
+		Content    string `json:"content"`
+	} `json:"alternatives"`
+}
+
+// 候補の先頭の内容（候補がなければ空）
+func (it transcriptItem) content() string {
+	if len(it.Alternatives) == 0 {
+		return ""
+	}
+	return it.Alternatives[0].Content
+}
+
+// 話者ラベルの付いた区間
+type speakerSegment struct {
+	SpeakerLabel string `json:"speaker_label"`
+	Items        []struct {
+		StartTime    string `json:"start_time"`
+		SpeakerLabel string `json:"speaker_label"`
+	} `json:"items"`
+}
+
+// 結果JSONから書き出したファイル
+type transcriptOutput struct {
+	Text         string
+	Plain        string // --min-confidence の印を付ける前の文字起こし
+	TextFile     string
+	SubtitleFile string  // --subtitles の字幕ファイル（指定しなければ空）
+	Confidence   float64 // 単語の信頼度の平均（単語がなければ0）
+}
+
+// 文字起こしジョブの結果JSONを置くS3キー（<プレフィックス><ジョブ名>.json）
+func transcriptOutputKey(prefix, jobName string) string {
+	return prefix + jobName + ".json"
+}
+
+// 結果JSONをS3から取得し、文字起こしテキストを <ジョブ名>.txt に書き出す
+// --subtitles の場合は単語のタイムスタンプから字幕ファイルも書き出す
+func downloadTranscript(ctx context.Context, s3Svc S3API, cfg *Config, jobName string) (transcriptOutput, error) {
+	key := transcriptOutputKey(cfg.TranscribeOutputPrefix, jobName)
+
+	callCtx, cancel := callContext(ctx, cfg.Timeout)
+	defer cancel()
+	output, err := s3Svc.GetObjectWithContext(callCtx, &s3.GetObjectInput{
+		Bucket: aws.String(cfg.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return transcriptOutput{}, fmt.Errorf("downloading transcript %s: %w", key, err)
+	}
+	defer output.Body.Close()
+
+	result, err := parseTranscript(output.Body)
+	if err != nil {
+		return transcriptOutput{}, fmt.Errorf("parsing transcript %s: %w", key, err)
+	}
+	if len(result.Results.Transcripts) == 0 {
+		return transcriptOutput{}, fmt.Errorf("transcript %s contains no results", key)
+	}
+
+	texts := make([]string, 0, len(result.Results.Transcripts))
+	for _, t := range result.Results.Transcripts {
+		texts = append(texts, t.Transcript)
+	}
+	plain := strings.Join(texts, " ")
+	out := transcriptOutput{Text: plain, Plain: plain, TextFile: jobName + ".txt"}
+	out.Confidence = averageConfidence(result.Results.Items)
+	// --min-confidence の場合は信頼度の低い単語を [ ] で囲んで書き出す
+	if cfg.MinConfidence > 0 && len(result.Results.Items) > 0 {
+		out.Text = markLowConfidence(result.Results.Items, cfg.MinConfidence)
+	}
+	// --show-speakers の場合は話者ごとの発言に分けて、先頭に話者ラベルを付ける
+	if cfg.ShowSpeakers && len(result.Results.Items) > 0 {
+		out.Text = formatSpeakerTurns(speakerTurns(result), cfg.MinConfidence)
+	}
+	if err := os.WriteFile(out.TextFile, []byte(out.Text+"\n"), 0o644); err != nil {
+		return transcriptOutput{}, err
+	}
+
+	if cfg.Subtitles != "" {
+		cues, err := buildCues(result.Results.Items, cfg.SubtitleMaxChars)
+		if err != nil {
+			return transcriptOutput{}, fmt.Errorf("building subtitles from %s: %w", key, err)
+		}
+		out.SubtitleFile = jobName + "." + cfg.Subtitles
+		if err := os.WriteFile(out.SubtitleFile, []byte(formatSubtitles(cues, cfg.Subtitles)), 0o644); err != nil {
+			return transcriptOutput{}, err
+		}
+	}
+	return out, nil
+}
+
+// 単語の信頼度（読めなければ0）
+func (it transcriptItem) confidence() float64 {
+	if len(it.Alternatives) == 0 {
+		return 0
+	}
+	c, _ := strconv.ParseFloat(it.Alternatives[0].Confidence, 64)
+	return c
+}
+
+// 単語の信頼度の平均を求める（句読点には信頼度がないため除く）
+func averageConfidence(items []transcriptItem) float64 {
+	var sum float64
+	var n int
+	for _, it := range items {
+		if it.Type == "punctuation" {
+			continue
+		}
+		sum += it.confidence()
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// 単語をつなげてテキストに戻し、信頼度が min 未満の単語を [ ] で囲む
+func markLowConfidence(items []transcriptItem, min float64) string {
+	var b strings.Builder
+	for _, it := range items {
+		content := it.content()
+		if it.Type == "punctuation" {
+			b.WriteString(content)
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(" ")
+		}
+		if it.confidence() < min {
+			content = "[" + content + "]"
+		}
+		b.WriteString(content)
+	}
+	return b.String()
+}
+
+// 結果JSONを読み込む
+func parseTranscript(r io.Reader) (*transcribeResult, error) {
+	var result transcribeResult
+	if err := json.NewDecoder(r).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// 1人の話者が続けて話した部分
+type speakerTurn struct {
+	Speaker string
+	Items   []transcriptItem
+}
+
+// 単語を話者ごとの発言にまとめる
+// 単語に話者ラベルがなければ speaker_labels の区間から開始時刻で引き、句読点は直前の単語の話者に含める
+func speakerTurns(result *transcribeResult) []speakerTurn {
+	byStart := make(map[string]string)
+	for _, seg := range result.Results.SpeakerLabels.Segments {
+		for _, it := range seg.Items {
+			byStart[it.StartTime] = it.SpeakerLabel
+		}
+	}
+
+	var turns []speakerTurn
+	for _, it := range result.Results.Items {
+		speaker := it.SpeakerLabel
+		if speaker == "" {
+			speaker = byStart[it.StartTime]
+		}
+		if it.Type == "punctuation" || speaker == "" {
+			if len(turns) > 0 {
+				last := &turns[len(turns)-1]
+				last.Items = append(last.Items, it)
+				continue
+			}
+		}
+		if len(turns) == 0 || turns[len(turns)-1].Speaker != speaker {
+			turns = append(turns, speakerTurn{Speaker: speaker})
+		}
+		last := &turns[len(turns)-1]
+		last.Items = append(last.Items, it)
+	}
+	return turns
+}
+
+// 発言ごとに "spk_0: テキスト" の1行にする
+func formatSpeakerTurns(turns []speakerTurn, minConfidence float64) string {
+	lines := make([]string, 0, len(turns))
+	for _, t := range turns {
+		speaker := t.Speaker
+		if speaker == "" {
+			speaker = "unknown"
+		}
+		lines = append(lines, speaker+": "+markLowConfidence(t.Items, minConfidence))
+	}
+	return strings.Join(lines, "\n")
+}
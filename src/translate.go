@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/translate"
+	"golang.org/x/time/rate"
+)
+
+// 翻訳元言語を自動判定させる場合の指定値
+const autoDetectLanguage = "auto"
+
+// ドライランで訳文の代わりに返す、原文に付ける印
+const dryRunPrefix = "[DRYRUN] "
+
+// 翻訳の結果
+type translationResult struct {
+	Text         string // 翻訳後のテキスト
+	DetectedLang string // Translate が判定した翻訳元言語
+	Cached       bool   // キャッシュから取得したか（Translate を呼び出していない）
+}
+
+// Translate が1回のリクエストで受け付けるテキストの上限（UTF-8のバイト数）
+const maxTranslateBytes = 10000
+
+// 入力テキストを指定の言語に翻訳する（AWS SDK for Goを使用）
+// 上限を超える長さのテキストは文の区切りで分割して翻訳し、訳文をつなげて返す
+// 同じ原文・言語の組み合わせの訳は cache から返す（--dynamodb-table の場合はテーブルからも探し、新しい訳を記録する）
+func translateText(ctx context.Context, translateSvc TranslateAPI, cfg *Config, limiter *rate.Limiter, cache *translationCache, text, sourceLang, targetLang string) (translationResult, error) {
+	if cfg.DryRun {
+		return translationResult{Text: dryRunPrefix + text}, nil
+	}
+
+	key := cacheKey{SourceLang: sourceLang, TargetLang: targetLang, Settings: translationSettings(cfg), Text: text}
+	if res, ok := cache.get(key); ok {
+		res.Cached = true
+		return res, nil
+	}
+	res, ok, err := cache.getRemote(ctx, key)
+	if err != nil {
+		return translationResult{}, fmt.Errorf("reading translation memory: %w", err)
+	}
+	if ok {
+		res.Cached = true
+		return res, nil
+	}
+	res, err = translateUncached(ctx, translateSvc, cfg, limiter, text, sourceLang, targetLang)
+	if err != nil {
+		return translationResult{}, err
+	}
+	cache.put(key, res)
+	if err := cache.putRemote(ctx, key, res); err != nil {
+		return translationResult{}, fmt.Errorf("writing translation memory: %w", err)
+	}
+	return res, nil
+}
+
+// テキストを翻訳する（キャッシュを使わない）
+// --protect-pattern に一致する部分は印に置き換えて翻訳し、訳文で元に戻す
+func translateUncached(ctx context.Context, translateSvc TranslateAPI, cfg *Config, limiter *rate.Limiter, text, sourceLang, targetLang string) (translationResult, error) {
+	original := text
+	text, tokens := protectTokens(text, cfg.protect)
+
+	chunks := splitText(text, maxTranslateBytes, func(s string) int { return len(s) })
+	var result translationResult
+	translated := make([]string, 0, len(chunks))
+	for i, chunk := range chunks {
+		res, err := translateChunk(ctx, translateSvc, cfg, limiter, chunk, sourceLang, targetLang)
+		if err != nil {
+			return translationResult{}, fmt.Errorf("chunk %d of %d: %w", i+1, len(chunks), err)
+		}
+		if i == 0 {
+			result.DetectedLang = res.DetectedLang
+			// 自動判定の結果が翻訳先と同じ言語なら、残りの塊は翻訳せず原文をそのまま使う
+			if sourceLang == autoDetectLanguage && res.DetectedLang == targetLang {
+				return translationResult{Text: original, DetectedLang: res.DetectedLang}, nil
+			}
+			// 残りの塊は最初の塊で判定した言語から翻訳する
+			if sourceLang == autoDetectLanguage && res.DetectedLang != "" {
+				sourceLang = res.DetectedLang
+			}
+		}
+		translated = append(translated, res.Text)
+	}
+	restored, err := restoreTokens(joinChunks(translated), tokens)
+	if err != nil {
+		return translationResult{}, err
+	}
+	result.Text = restored
+	return result, nil
+}
+
+// 上限以下のテキスト1つを翻訳する
+func translateChunk(ctx context.Context, translateSvc TranslateAPI, cfg *Config, limiter *rate.Limiter, text, sourceLang, targetLang string) (translationResult, error) {
+	translateInput := &translate.TextInput{
+		Text:               aws.String(text),
+		SourceLanguageCode: aws.String(sourceLang),
+		TargetLanguageCode: aws.String(targetLang),
+	}
+	if len(cfg.Terminologies) > 0 {
+		translateInput.TerminologyNames = aws.StringSlice(cfg.Terminologies)
+	}
+	if cfg.Formality != "" || cfg.MaskProfanity {
+		translateInput.Settings = &translate.TranslationSettings{}
+		if cfg.Formality != "" {
+			translateInput.Settings.Formality = aws.String(cfg.Formality)
+		}
+		if cfg.MaskProfanity {
+			translateInput.Settings.Profanity = aws.String(translate.ProfanityMask)
+		}
+	}
+	var translateResult *translate.TextOutput
+	call := func() error {
+		return withRetry(ctx, cfg, func() error {
+			// トークンの取得待ちもキャンセルで中断できるようにする
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+			callCtx, cancel := callContext(ctx, cfg.Timeout)
+			defer cancel()
+			var err error
+			translateResult, err = translateSvc.TextWithContext(callCtx, translateInput)
+			return err
+		})
+	}
+	err := call()
+	// 言語の組み合わせが敬語の指定に対応していない場合は、--strict でなければ指定を外してやり直す
+	if err != nil && cfg.Formality != "" && isFormalityUnsupported(err) {
+		if cfg.Strict {
+			return translationResult{}, fmt.Errorf("--formality %s is not supported for %s to %s: %w", cfg.Formality, sourceLang, targetLang, err)
+		}
+		slog.Warn("formality is not supported for this language pair; translating without it",
+			"source_lang", sourceLang, "target_lang", targetLang, "error", err)
+		translateInput.Settings.Formality = nil
+		err = call()
+	}
+	if err != nil {
+		return translationResult{}, err
+	}
+	detectedLang := aws.StringValue(translateResult.SourceLanguageCode)
+	// 自動判定の結果が翻訳先と同じ言語なら、原文をそのまま使う
+	// （判定は翻訳と同じ呼び出しで行われるため、1回目の呼び出し自体は省略できない）
+	if sourceLang == autoDetectLanguage && detectedLang == targetLang {
+		return translationResult{Text: text, DetectedLang: detectedLang}, nil
+	}
+	return translationResult{Text: *translateResult.TranslatedText, DetectedLang: detectedLang}, nil
+}
+
+// 敬語の指定に対応していない言語の組み合わせで返るエラーか判定する
+func isFormalityUnsupported(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	switch aerr.Code() {
+	case translate.ErrCodeUnsupportedLanguagePairException, "ValidationException":
+		return strings.Contains(strings.ToLower(aerr.Message()), "formality")
+	}
+	return false
+}
+
+// --terminology で指定したカスタム用語集がリージョンに登録されているか確認する
+func checkTerminologies(ctx context.Context, translateSvc TranslateAPI, cfg *Config) error {
+	if len(cfg.Terminologies) == 0 {
+		return nil
+	}
+	registered := make(map[string]bool)
+	input := &translate.ListTerminologiesInput{}
+	for {
+		callCtx, cancel := callContext(ctx, cfg.Timeout)
+		output, err := translateSvc.ListTerminologiesWithContext(callCtx, input)
+		cancel()
+		if err != nil {
+			return err
+		}
+		for _, t := range output.TerminologyPropertiesList {
+			registered[aws.StringValue(t.Name)] = true
+		}
+		if aws.StringValue(output.NextToken) == "" {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+	for _, name := range cfg.Terminologies {
+		if !registered[name] {
+			return fmt.Errorf("terminology %q does not exist in region %s (import it with aws translate import-terminology)", name, cfg.Region)
+		}
+	}
+	return nil
+}
+
+// Translate 用のトークンバケットを作る（rps が0なら無制限）
+func newTranslateLimiter(rps float64) *rate.Limiter {
+	if rps == 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	burst := int(rps)
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(rps), burst)
+}
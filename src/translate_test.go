@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"golang.org/x/time/rate"
+)
+
+func TestTranslateText(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		want      string
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "success", want: "[en] こんにちは", wantCalls: 1},
+		{name: "throttled then success", errs: []error{errThrottled, errThrottled}, want: "[en] こんにちは", wantCalls: 3},
+		{name: "throttled past max retries", errs: []error{errThrottled, errThrottled, errThrottled, errThrottled}, wantCalls: 4, wantErr: true},
+		{name: "not retried", errs: []error{awserr.New("AccessDeniedException", "denied", nil)}, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, "--source-lang", "ja", "--max-retries", "3")
+			svc := &fakeTranslate{failures: failures{errs: tt.errs}}
+			got, err := translateText(context.Background(), svc, cfg, rate.NewLimiter(rate.Inf, 0), newTranslationCache(), "こんにちは", "ja", "en")
+			if calls := svc.count(); calls != tt.wantCalls {
+				t.Errorf("TextWithContext called %d times, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %q, want an error", got.Text)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("translateText: %v", err)
+			}
+			if got.Text != tt.want || got.Cached {
+				t.Errorf("got %+v, want %q from Translate", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslateTextCache(t *testing.T) {
+	cfg := testConfig(t, "--source-lang", "ja")
+	svc := &fakeTranslate{}
+	cache := newTranslationCache()
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := translateText(context.Background(), svc, cfg, limiter, cache, "こんにちは", "ja", "en"); err != nil {
+			t.Fatalf("translateText: %v", err)
+		}
+	}
+	got, err := translateText(context.Background(), svc, cfg, limiter, cache, "こんにちは", "ja", "en")
+	if err != nil || !got.Cached {
+		t.Fatalf("got %+v, %v; want a cached translation", got, err)
+	}
+	if calls := svc.count(); calls != 1 {
+		t.Errorf("TextWithContext called %d times, want 1", calls)
+	}
+	if cache.hits.Load() != 2 || cache.misses.Load() != 1 {
+		t.Errorf("cache hits %d misses %d, want 2 and 1", cache.hits.Load(), cache.misses.Load())
+	}
+}
+
+func TestTranslateLineWrapsErrTranslate(t *testing.T) {
+	denied := awserr.New("AccessDeniedException", "denied", nil)
+	p := testPipeline(testConfig(t), &Clients{Translate: &fakeTranslate{failures: failures{errs: []error{denied}}}}, modeTranslate)
+	var detected string
+	res := p.translateLine(context.Background(), "input.txt", 3, "en", "", "こんにちは", &detected)
+	if !errors.Is(res.Err, ErrTranslate) || errors.Is(res.Err, ErrSynthesize) {
+		t.Errorf("got %v, want an error from the translate stage", res.Err)
+	}
+	var aerr awserr.Error
+	if !errors.As(res.Err, &aerr) || aerr.Code() != "AccessDeniedException" {
+		t.Errorf("got %v, want the AccessDeniedException from Translate", res.Err)
+	}
+	if res.Line != 3 || res.InputPath != "input.txt" {
+		t.Errorf("result is for %s line %d, want input.txt line 3", res.InputPath, res.Line)
+	}
+}
+
+func BenchmarkTranslateText(b *testing.B) {
+	cfg := testConfig(b, "--source-lang", "ja")
+	svc := &fakeTranslate{}
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	text := strings.Repeat("今日は良い天気です。", 100)
+	b.Run("miss", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := translateText(context.Background(), svc, cfg, limiter, newTranslationCache(), text, "ja", "en"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("hit", func(b *testing.B) {
+		cache := newTranslationCache()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := translateText(context.Background(), svc, cfg, limiter, cache, text, "ja", "en"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
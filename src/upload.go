@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// --output-to-s3 でアップロードする翻訳結果とマニフェストの Content-Type
+const (
+	translatedTextContentType = "text/plain; charset=utf-8"
+	manifestContentType       = "application/json"
+)
+
+// アップロードする S3 オブジェクトの指定を作る
+// --storage-class・--sse・--kms-key-id の指定は全てのアップロードに付ける
+func newUploadInput(cfg *Config, key string, body io.Reader, contentType string) *s3manager.UploadInput {
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(cfg.Bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	}
+	if cfg.StorageClass != s3.StorageClassStandard {
+		input.StorageClass = aws.String(cfg.StorageClass)
+	}
+	// バケットポリシーで暗号化が必須の場合に備えて、--sse の指定を付ける
+	if cfg.SSE != "" {
+		input.ServerSideEncryption = aws.String(cfg.SSE)
+	}
+	if cfg.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(cfg.KMSKeyID)
+	}
+	return input
+}
+
+// S3 のオブジェクトに付けられるタグの数と、キー・値の長さ（文字数）の上限
+const (
+	maxObjectTags     = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// 音声のオブジェクトに必ず付けるタグのキー
+var audioTagKeys = []string{"source-lang", "target-lang", "voice"}
+
+// S3 のタグのキーと値に、文字・数字・空白のほかに使える記号
+const tagSymbols = "+-=._:/@"
+
+// S3 のタグに使える文字だけからなるか（それ以外はアップロードが InvalidTag で失敗する）
+func isTagText(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && !strings.ContainsRune(tagSymbols, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// --tag の指定が S3 のタグの制限に収まるか確かめる
+func validateTags(tags map[string]string) error {
+	extra := 0
+	for k, v := range tags {
+		if strings.HasPrefix(strings.ToLower(k), "aws:") {
+			return fmt.Errorf("--tag key %q: the aws: prefix is reserved", k)
+		}
+		if utf8.RuneCountInString(k) > maxTagKeyLength || utf8.RuneCountInString(v) > maxTagValueLength {
+			return fmt.Errorf("--tag %s=%s: keys must be at most %d and values at most %d characters", k, v, maxTagKeyLength, maxTagValueLength)
+		}
+		if !isTagText(k) || !isTagText(v) {
+			return fmt.Errorf("--tag %s=%s: keys and values may contain only letters, digits, spaces and %s", k, v, tagSymbols)
+		}
+		if !slices.Contains(audioTagKeys, k) {
+			extra++
+		}
+	}
+	if limit := maxObjectTags - len(audioTagKeys); extra > limit {
+		return fmt.Errorf("--tag accepts at most %d tags, got %d", limit, extra)
+	}
+	return nil
+}
+
+// 音声のオブジェクトに付けるメタデータ（x-amz-meta-*）とタグ
+type objectLabels struct {
+	Metadata map[string]string
+	Tags     map[string]string
+}
+
+// 行の処理結果から、音声のオブジェクトに付けるメタデータとタグを作る
+// 音声は内容からキーが決まるため、同じ音声になる別の行では最初にアップロードした行の値が残る
+func audioLabels(cfg *Config, res *LineResult) objectLabels {
+	sourceLang := cfg.SourceLang
+	if res.DetectedLang != "" {
+		sourceLang = res.DetectedLang
+	}
+	labels := objectLabels{
+		// メタデータは HTTP ヘッダーで送るため、ASCII 以外を含みうる入力のパスはエスケープする
+		Metadata: map[string]string{
+			"source-file": (&url.URL{Path: res.InputPath}).EscapedPath(),
+			"source-line": strconv.Itoa(res.Line),
+			"source-lang": sourceLang,
+			"target-lang": res.TargetLang,
+			"voice":       res.Voice,
+		},
+		Tags: map[string]string{
+			"source-lang": sourceLang,
+			"target-lang": res.TargetLang,
+			"voice":       res.Voice,
+		},
+	}
+	for k, v := range cfg.Tags {
+		labels.Tags[k] = v
+	}
+	return labels
+}
+
+// アップロードの指定にメタデータとタグを付ける
+// タグは S3 の求める URL のクエリ文字列の形式（key1=value1&key2=value2）にエンコードする
+func (l objectLabels) apply(input *s3manager.UploadInput) {
+	if len(l.Metadata) > 0 {
+		input.Metadata = aws.StringMap(l.Metadata)
+	}
+	if len(l.Tags) > 0 {
+		pairs := make([]string, 0, len(l.Tags))
+		for k, v := range l.Tags {
+			pairs = append(pairs, escapeTag(k)+"="+escapeTag(v))
+		}
+		sort.Strings(pairs)
+		input.Tagging = aws.String(strings.Join(pairs, "&"))
+	}
+}
+
+// タグのキー・値をエンコードする（空白は + ではなく %20 にする）
+func escapeTag(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// 翻訳結果のファイルをアップロードするキー
+// --input-dir の場合は --output-dir からの相対パスを保ち、それ以外はファイル名だけを使う
+func outputKeyFor(cfg *Config, outputPath string) string {
+	name := filepath.Base(outputPath)
+	if cfg.InputDir != "" {
+		if rel, err := filepath.Rel(cfg.OutputDir, outputPath); err == nil {
+			name = filepath.ToSlash(rel)
+		}
+	}
+	return cfg.S3Prefix + name
+}
+
+// 翻訳結果のファイルをバケットにアップロードし、キーを返す
+func uploadOutputFile(ctx context.Context, uploader UploaderAPI, cfg *Config, outputPath string) (string, error) {
+	key := outputKeyFor(cfg, outputPath)
+	return key, uploadFile(ctx, uploader, cfg, outputPath, key, translatedTextContentType)
+}
+
+// --manifest のファイルを --s3-prefix の下にアップロードし、キーを返す
+func uploadManifest(ctx context.Context, uploader UploaderAPI, cfg *Config) (string, error) {
+	key := cfg.S3Prefix + filepath.Base(cfg.ManifestPath)
+	return key, uploadFile(ctx, uploader, cfg, cfg.ManifestPath, key, manifestContentType)
+}
+
+// ローカルのファイルを key にアップロードする（ドライランでは何もしない）
+func uploadFile(ctx context.Context, uploader UploaderAPI, cfg *Config, path, key, contentType string) error {
+	if cfg.DryRun {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	uploadCtx, cancel := callContext(ctx, cfg.Timeout)
+	defer cancel()
+	if _, err := uploader.UploadWithContext(uploadCtx, newUploadInput(cfg, key, file, contentType)); err != nil {
+		return fmt.Errorf("uploading %s: %w", path, err)
+	}
+	return nil
+}
+
+// body を別の goroutine で io.Pipe に書き込み、アップローダーはパイプから読んで input の指定でアップロードする
+// 合成とアップロードが並行して進み、メモリに溜まるのはアップローダーのパートのバッファだけになる
+// （wav は wrapWAV がヘッダーにデータの長さを書くために音声全体を読み込むため、音声の大きさに比例したメモリを使う）
+// local が nil でなければ、パイプへ書くのと同じ内容を local にも書く
+// 読み込み（合成）や local への書き込みの失敗はそのまま、アップロードの失敗は ErrUpload を付けて返す
+func uploadStream(ctx context.Context, uploader UploaderAPI, cfg *Config, input *s3manager.UploadInput, body io.Reader, local io.Writer) error {
+	pr, pw := io.Pipe()
+	copied := make(chan error, 1)
+	go func() {
+		var dst io.Writer = pw
+		if local != nil {
+			dst = io.MultiWriter(pw, local)
+		}
+		_, err := io.Copy(dst, body)
+		// エラーで閉じるとアップローダーの読み込みもそのエラーで終わる（nil なら EOF）
+		pw.CloseWithError(err)
+		copied <- err
+	}()
+
+	uploadCtx, cancel := callContext(ctx, cfg.Timeout)
+	defer cancel()
+	input.Body = pr
+	_, uploadErr := uploader.UploadWithContext(uploadCtx, input)
+	// アップロードが途中で失敗しても書き込み側が止まるよう、読み込み側を閉じてから終わりを待つ
+	pr.Close()
+	if err := <-copied; err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return err
+	}
+	if uploadErr != nil {
+		return withStage(ErrUpload, uploadErr)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestUploadStreamBoundedMemory(t *testing.T) {
+	cfg := &Config{Bucket: "bucket"}
+	upload := func(size int64) {
+		uploader := &fakeUploader{}
+		input := newUploadInput(cfg, "audio.mp3", nil, "audio/mpeg")
+		if err := uploadStream(context.Background(), uploader, cfg, input, &zeroStream{size: size}, nil); err != nil {
+			t.Fatalf("uploadStream: %v", err)
+		}
+		if got := uploader.sizes["audio.mp3"]; got != size {
+			t.Fatalf("uploaded %d bytes, want %d", got, size)
+		}
+	}
+
+	// 回数・量ともに、音声の大きさによらず一定であること
+	small := testing.AllocsPerRun(5, func() { upload(1 << 20) })
+	large := testing.AllocsPerRun(5, func() { upload(16 << 20) })
+	if large > small+5 {
+		t.Errorf("allocations grew with the stream size: %.0f for 1 MiB, %.0f for 16 MiB", small, large)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	upload(16 << 20)
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("uploading 16 MiB allocated %d bytes, want under 1 MiB", allocated)
+	}
+}
+
+func TestUploadStreamErrors(t *testing.T) {
+	cfg := &Config{Bucket: "bucket"}
+	pollyErr := errors.New("stream reset")
+	uploadErr := errors.New("access denied")
+
+	tests := []struct {
+		name       string
+		body       *zeroStream
+		uploader   *fakeUploader
+		wantErr    error
+		wantUpload bool // ErrUpload を付けて返すか
+	}{
+		{
+			// 書き込み側の失敗は CloseWithError でアップローダーの読み込みを終わらせる
+			name:     "stream fails",
+			body:     &zeroStream{size: 8 << 20, failAfter: 1 << 20, err: pollyErr},
+			uploader: &fakeUploader{},
+			wantErr:  pollyErr,
+		},
+		{
+			// アップロードが読まずに失敗しても、書き込み側は止まる
+			name:       "upload fails",
+			body:       &zeroStream{size: 8 << 20},
+			uploader:   &fakeUploader{err: uploadErr},
+			wantErr:    uploadErr,
+			wantUpload: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan error, 1)
+			go func() {
+				input := newUploadInput(cfg, "audio.mp3", nil, "audio/mpeg")
+				done <- uploadStream(context.Background(), tt.uploader, cfg, input, tt.body, nil)
+			}()
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("uploadStream did not return")
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if got := errors.Is(err, ErrUpload); got != tt.wantUpload {
+				t.Errorf("errors.Is(err, ErrUpload) = %v, want %v", got, tt.wantUpload)
+			}
+			if len(tt.uploader.uploads) != 0 {
+				t.Errorf("object %s was uploaded despite the error", aws.StringValue(tt.uploader.uploads[0].Key))
+			}
+		})
+	}
+}
+
+func TestNewUploadInputEncryption(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantSSE string
+		wantKMS string
+	}{
+		{name: "none"},
+		{name: "AES256", args: []string{"--sse", "AES256"}, wantSSE: "AES256"},
+		{name: "KMS default key", args: []string{"--sse", "aws:kms"}, wantSSE: "aws:kms"},
+		{name: "KMS key", args: []string{"--sse", "aws:kms", "--kms-key-id", "alias/audio"}, wantSSE: "aws:kms", wantKMS: "alias/audio"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, append([]string{"--bucket", "bucket"}, tt.args...)...)
+			input := newUploadInput(cfg, "audio.mp3", nil, "audio/mpeg")
+			if got := aws.StringValue(input.ServerSideEncryption); got != tt.wantSSE {
+				t.Errorf("ServerSideEncryption = %q, want %q", got, tt.wantSSE)
+			}
+			if got := aws.StringValue(input.SSEKMSKeyId); got != tt.wantKMS {
+				t.Errorf("SSEKMSKeyId = %q, want %q", got, tt.wantKMS)
+			}
+			if tt.wantSSE == "" && (input.ServerSideEncryption != nil || input.SSEKMSKeyId != nil) {
+				t.Errorf("encryption fields set without --sse")
+			}
+		})
+	}
+
+	for _, args := range [][]string{{"--sse", "aws:KMS"}, {"--kms-key-id", "alias/audio"}, {"--sse", "AES256", "--kms-key-id", "alias/audio"}} {
+		if _, err := parseFlags(append([]string{"--bucket", "bucket"}, args...), nil); err == nil {
+			t.Errorf("parseFlags(%q) accepted an invalid encryption setting", args)
+		}
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    map[string]string
+		wantErr bool
+	}{
+		{name: "allowed characters", tags: map[string]string{"project": "demo 1", "path": "a/b:c@d+e=f.g_h-i", "チーム": "音声"}},
+		{name: "ampersand", tags: map[string]string{"k": "a&b"}, wantErr: true},
+		{name: "angle bracket", tags: map[string]string{"k<v": "x"}, wantErr: true},
+		{name: "percent", tags: map[string]string{"k": "100%"}, wantErr: true},
+		{name: "aws prefix", tags: map[string]string{"aws:owner": "x"}, wantErr: true},
+		{name: "too long", tags: map[string]string{"k": strings.Repeat("v", maxTagValueLength+1)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateTags(tt.tags); (err != nil) != tt.wantErr {
+				t.Errorf("validateTags(%q) = %v, want error %v", tt.tags, err, tt.wantErr)
+			}
+		})
+	}
+
+	// 使えない文字は parseFlags の時点で弾く
+	if _, err := parseFlags([]string{"--bucket", "bucket", "--tag", "team=a&b"}, nil); err == nil {
+		t.Error("parseFlags accepted --tag team=a&b")
+	}
+}
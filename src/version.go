@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// ビルド時に埋め込むバージョン情報
+// go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version = "dev"
+	commit  string
+	date    string
+)
+
+// version: バージョン・コミット・ビルド日時を表示する
+func versionCommand() command {
+	return command{
+		name:    "version",
+		summary: "Print the version, git commit and build date",
+		local:   true,
+		run: func(ctx context.Context, clients *Clients, cfg *Config) error {
+			printVersion(os.Stdout)
+			return nil
+		},
+	}
+}
+
+// -ldflags で埋め込まれていなければ、go build が記録した VCS の情報を使う
+func printVersion(w io.Writer) {
+	c, d := commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && c == "":
+				c = s.Value[:min(len(s.Value), 12)]
+			case s.Key == "vcs.time" && d == "":
+				d = s.Value
+			}
+		}
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+	fmt.Fprintf(w, "%s (commit %s, built %s, %s)\n", version, c, d, runtime.Version())
+}
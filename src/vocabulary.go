@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/transcribeservice"
+)
+
+// --vocabulary-name のカスタム語彙が languageCode の文字起こしに使えるか事前に確認する
+// 作成中（PENDING）の場合は --strict ならエラーにし、そうでなければ READY になるまで待つ
+func checkVocabulary(ctx context.Context, transcribeSvc TranscribeAPI, cfg *Config, languageCode string) error {
+	if cfg.VocabularyName == "" {
+		return nil
+	}
+	if cfg.TranscribeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.TranscribeTimeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		var output *transcribeservice.GetVocabularyOutput
+		err := withRetry(ctx, cfg, func() error {
+			callCtx, cancel := callContext(ctx, cfg.Timeout)
+			defer cancel()
+			var err error
+			output, err = transcribeSvc.GetVocabularyWithContext(callCtx, &transcribeservice.GetVocabularyInput{
+				VocabularyName: aws.String(cfg.VocabularyName),
+			})
+			return err
+		})
+		// 存在しない語彙は BadRequestException になる
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && (aerr.Code() == transcribeservice.ErrCodeBadRequestException || aerr.Code() == transcribeservice.ErrCodeNotFoundException) {
+			return fmt.Errorf("vocabulary %q does not exist in region %s (create it with aws transcribe create-vocabulary): %w", cfg.VocabularyName, cfg.Region, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		if lang := aws.StringValue(output.LanguageCode); lang != languageCode {
+			return fmt.Errorf("vocabulary %q is for %s, but the audio is transcribed as %s", cfg.VocabularyName, lang, languageCode)
+		}
+		switch aws.StringValue(output.VocabularyState) {
+		case transcribeservice.VocabularyStateReady:
+			return nil
+		case transcribeservice.VocabularyStateFailed:
+			return fmt.Errorf("vocabulary %q failed: %s", cfg.VocabularyName, aws.StringValue(output.FailureReason))
+		}
+		if cfg.Strict {
+			return fmt.Errorf("vocabulary %q is not ready yet (state %s)", cfg.VocabularyName, aws.StringValue(output.VocabularyState))
+		}
+		slog.Info("waiting for vocabulary to become ready", "vocabulary", cfg.VocabularyName, "state", aws.StringValue(output.VocabularyState))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for vocabulary %q: %w", cfg.VocabularyName, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// --audio-format wav の値（Polly には pcm を要求し、WAV のヘッダーを付けて保存する）
+const audioFormatWav = "wav"
+
+// Polly の pcm 出力は 16bit 符号付きリトルエンディアンのモノラル
+const (
+	pcmChannels      = 1
+	pcmBitsPerSample = 16
+)
+
+// WAV（RIFF）形式のヘッダーの長さ
+const wavHeaderSize = 44
+
+// dataSize バイトの PCM サンプルの前に付ける WAV のヘッダーを作る
+func wavHeader(dataSize, sampleRate int) []byte {
+	blockAlign := pcmChannels * pcmBitsPerSample / 8
+	header := make([]byte, 0, wavHeaderSize)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(wavHeaderSize-8+dataSize))
+	header = append(header, "WAVE"...)
+	header = append(header, "fmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16) // fmt チャンクの長さ
+	header = binary.LittleEndian.AppendUint16(header, 1)  // リニア PCM
+	header = binary.LittleEndian.AppendUint16(header, pcmChannels)
+	header = binary.LittleEndian.AppendUint32(header, uint32(sampleRate))
+	header = binary.LittleEndian.AppendUint32(header, uint32(sampleRate*blockAlign))
+	header = binary.LittleEndian.AppendUint16(header, uint16(blockAlign))
+	header = binary.LittleEndian.AppendUint16(header, pcmBitsPerSample)
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(dataSize))
+	return header
+}
+
+// PCM のストリームを読み切り、WAV のヘッダーを付けて返す
+// ヘッダーにデータの長さが必要なため、ストリームのまま送らずにメモリに読み込む
+func wrapWAV(pcm io.Reader, sampleRate int) (io.Reader, error) {
+	var data bytes.Buffer
+	if _, err := data.ReadFrom(pcm); err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(wavHeader(data.Len(), sampleRate)), &data), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWavHeader(t *testing.T) {
+	tests := []struct {
+		sampleRate   int
+		dataSize     int
+		wantByteRate uint32
+	}{
+		{sampleRate: 8000, dataSize: 16000, wantByteRate: 16000},
+		{sampleRate: 16000, dataSize: 3200, wantByteRate: 32000},
+	}
+	for _, tt := range tests {
+		header := wavHeader(tt.dataSize, tt.sampleRate)
+		if len(header) != wavHeaderSize {
+			t.Fatalf("%d Hz: header is %d bytes, want %d", tt.sampleRate, len(header), wavHeaderSize)
+		}
+		le := binary.LittleEndian
+		for _, c := range []struct {
+			name string
+			got  any
+			want any
+		}{
+			{"RIFF", string(header[0:4]), "RIFF"},
+			{"RIFF size", le.Uint32(header[4:8]), uint32(36 + tt.dataSize)},
+			{"WAVE", string(header[8:12]), "WAVE"},
+			{"fmt", string(header[12:16]), "fmt "},
+			{"fmt size", le.Uint32(header[16:20]), uint32(16)},
+			{"audio format", le.Uint16(header[20:22]), uint16(1)},
+			{"channels", le.Uint16(header[22:24]), uint16(1)},
+			{"sample rate", le.Uint32(header[24:28]), uint32(tt.sampleRate)},
+			{"byte rate", le.Uint32(header[28:32]), tt.wantByteRate},
+			{"block align", le.Uint16(header[32:34]), uint16(2)},
+			{"bits per sample", le.Uint16(header[34:36]), uint16(16)},
+			{"data", string(header[36:40]), "data"},
+			{"data size", le.Uint32(header[40:44]), uint32(tt.dataSize)},
+		} {
+			if c.got != c.want {
+				t.Errorf("%d Hz: %s = %v, want %v", tt.sampleRate, c.name, c.got, c.want)
+			}
+		}
+	}
+}
+
+func TestWrapWAV(t *testing.T) {
+	pcm := strings.Repeat("\x01\x00", 800)
+	r, err := wrapWAV(strings.NewReader(pcm), 16000)
+	if err != nil {
+		t.Fatalf("wrapWAV: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading WAV: %v", err)
+	}
+	if !bytes.Equal(got[:wavHeaderSize], wavHeader(len(pcm), 16000)) || string(got[wavHeaderSize:]) != pcm {
+		t.Errorf("wrapWAV did not write the header followed by the PCM data")
+	}
+}